@@ -0,0 +1,142 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bitmap
+
+import (
+	"math"
+)
+
+// An Allocator hands out the lowest free IDs from 0 to a max ID, keeping
+// the IDs in use in a Bitmap. Full pages and full words are skipped while
+// looking for free IDs.
+type Allocator struct {
+	b   *Bitmap
+	max uint64
+}
+
+// Return back a new Allocator handing out IDs from 0 to max included
+func NewAllocator(max uint64) *Allocator {
+	option := &Option{
+		AutoExpand:  false,
+		AutoRecycle: true,
+		Capacity:    max + 1,
+	}
+
+	if max == math.MaxUint64 {
+		option.AutoExpand = true
+	}
+
+	return &Allocator{
+		b:   New(option),
+		max: max,
+	}
+}
+
+// nextClear returns the lowest free ID at n or after
+func (a *Allocator) nextClear(n uint64) (uint64, bool) {
+	b := a.b
+
+	for n <= a.max {
+		i, ok := b.searchPage(b.pageOf(n))
+		if !ok {
+			return n, true
+		}
+
+		o := b.pages[i].nextClear(b.offsetOf(n))
+		if o >= 0 {
+			if b.position(b.pageOf(n), o) > a.max {
+				return 0, false
+			}
+			return b.position(b.pageOf(n), o), true
+		}
+
+		if b.pageOf(n) == b.pageOf(a.max) {
+			break
+		}
+
+		n = b.position(b.pageOf(n)+1, 0)
+	}
+
+	return 0, false
+}
+
+// Alloc returns the lowest free ID
+func (a *Allocator) Alloc() (uint64, error) {
+	a.b.mu.Lock()
+	defer a.b.mu.Unlock()
+
+	n, ok := a.nextClear(0)
+	if !ok {
+		return 0, ErrExhausted
+	}
+
+	a.b.setBitInPage(n, true)
+	a.b.size++
+
+	return n, nil
+}
+
+// AllocRange returns the lowest ID starting n contiguous free IDs,
+// all of them allocated
+func (a *Allocator) AllocRange(n uint64) (uint64, error) {
+	if n == 0 {
+		return 0, ErrInvalidRange
+	}
+
+	a.b.mu.Lock()
+	defer a.b.mu.Unlock()
+
+	from := uint64(0)
+
+	for {
+		start, ok := a.nextClear(from)
+		if !ok {
+			return 0, ErrExhausted
+		}
+
+		end, ok := a.b.nextSet(start)
+		if !ok || end > a.max {
+			// Free up to the max ID
+			if a.max-start < n-1 {
+				return 0, ErrExhausted
+			}
+		} else if end-start < n {
+			from = end
+			continue
+		}
+
+		a.b.fillPages(start, start+n-1, rangeSet)
+
+		return start, nil
+	}
+}
+
+// Free gives an ID back
+func (a *Allocator) Free(id uint64) error {
+	a.b.mu.Lock()
+	defer a.b.mu.Unlock()
+
+	if id > a.max {
+		return ErrOutOfRange
+	}
+
+	if !a.b.setBitInPage(id, false) {
+		return ErrNotAllocated
+	}
+
+	a.b.size--
+
+	return nil
+}
+
+// Allocated returns whether the ID is in use
+func (a *Allocator) Allocated(id uint64) bool {
+	return a.b.Test(id)
+}
+
+// Size returns how many IDs are in use
+func (a *Allocator) Size() uint64 {
+	return a.b.Size()
+}
@@ -0,0 +1,152 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bitmap
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestAllocator(t *testing.T) {
+	a := NewAllocator(100)
+
+	for i := uint64(0); i <= 100; i++ {
+		if id, err := a.Alloc(); err != nil || id != i {
+			t.Fatalf("Should alloc %d", i)
+		}
+	}
+
+	if _, err := a.Alloc(); err != ErrExhausted {
+		t.Fatal("Every id should be in use")
+	}
+
+	a.Free(50)
+	a.Free(20)
+
+	if id, _ := a.Alloc(); id != 20 {
+		t.Fatal("Lowest free id should be reused first")
+	}
+
+	if err := a.Free(20); err != nil || a.Free(20) != ErrNotAllocated {
+		t.Fatal("Double free should be reported")
+	}
+
+	if a.Free(101) != ErrOutOfRange {
+		t.Fatal("101 is beyond the max id")
+	}
+
+	for i := uint64(60); i < 70; i++ {
+		a.Free(i)
+	}
+
+	if _, err := a.AllocRange(11); err != ErrExhausted {
+		t.Fatal("No 11 contiguous ids are free")
+	}
+
+	if id, err := a.AllocRange(10); err != nil || id != 60 {
+		t.Fatal("Should alloc the free block at 60")
+	}
+
+	if id, _ := a.AllocRange(1); id != 20 || a.Size() != 100 || a.Allocated(50) {
+		t.Fatal("Only 50 should be free")
+	}
+}
+
+func TestAllocatorRange(t *testing.T) {
+	a := NewAllocator(math.MaxUint64)
+
+	a.Alloc()
+
+	// Crosses the first page boundary
+	if id, err := a.AllocRange(uint64(bitsPerPage)); err != nil || id != 1 {
+		t.Fatal("Should alloc the block right after 0")
+	}
+
+	a.Free(5)
+
+	if id, _ := a.AllocRange(2); id != position(1, 1) {
+		t.Fatal("Block should start after the allocated one")
+	}
+
+	if id, _ := a.Alloc(); id != 5 || a.Size() != uint64(bitsPerPage)+3 {
+		t.Fatal("Alloc should fill the hole first")
+	}
+}
+
+func TestAllocatorRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	const max = 3000
+
+	a := NewAllocator(max)
+	used := make([]bool, max+1)
+
+	// lowest returns the lowest id starting n free ids in the model
+	lowest := func(n int) (uint64, bool) {
+		run := 0
+		for i, u := range used {
+			if u {
+				run = 0
+				continue
+			}
+
+			if run++; run == n {
+				return uint64(i - n + 1), true
+			}
+		}
+		return 0, false
+	}
+
+	for i := 0; i < 20000; i++ {
+		switch r.Intn(3) {
+		case 0:
+			id := r.Intn(max + 1)
+
+			if err := a.Free(uint64(id)); (err == nil) != used[id] {
+				t.Fatalf("Free(%d) reported %v", id, err)
+			}
+
+			used[id] = false
+		case 1:
+			n := r.Intn(50) + 1
+			expected, ok := lowest(n)
+
+			id, err := a.AllocRange(uint64(n))
+			if (err == nil) != ok || id != expected {
+				t.Fatalf("AllocRange(%d) should return %d, got %d", n, expected, id)
+			}
+
+			for j := 0; ok && j < n; j++ {
+				used[int(id)+j] = true
+			}
+		default:
+			expected, ok := lowest(1)
+
+			id, err := a.Alloc()
+			if (err == nil) != ok || id != expected {
+				t.Fatalf("Alloc should return %d, got %d", expected, id)
+			}
+
+			if ok {
+				used[id] = true
+			}
+		}
+	}
+
+	checkCounts(t, a.b)
+}
+
+func BenchmarkAllocatorAlloc(b *testing.B) {
+	a := NewAllocator(math.MaxUint64)
+	a.AllocRange(1 << 20)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		id, _ := a.Alloc()
+		a.Free(id)
+	}
+}
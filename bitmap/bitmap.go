@@ -3,19 +3,32 @@
 // license that can be found in the LICENSE file.
 
 // Package bitmap provides a sparsed bitmap implementation.
+//
+// Bits are numbered from 0 and can be anywhere in the uint64 range.
 package bitmap
 
 import (
-	"container/list"
+	"errors"
+	"math"
 	"os"
+	"sort"
 	"sync"
 )
 
+var (
+	ErrOutOfRange    = errors.New("bitmap: position out of range")
+	ErrInvalidRange  = errors.New("bitmap: range ends before it starts")
+	ErrInvalidFormat = errors.New("bitmap: invalid serialized bitmap")
+	ErrExhausted     = errors.New("bitmap: no free id left")
+	ErrNotAllocated  = errors.New("bitmap: id not allocated")
+)
+
 type Bitmap struct {
 	mu sync.Mutex
+	layout
 
-	size   int        // total elements stored
-	pages  *list.List // @Todo: here can be optimized
+	size   uint64  // total elements stored
+	pages  []*page // sorted by id, looked up by binary search
 	option *Option
 }
 
@@ -23,14 +36,50 @@ const (
 	bitsPerByte = 1 << 3 // default to 8bits per byte, only consider modern computing
 )
 
+// Default page layout
 var (
 	pageSize    = os.Getpagesize()
 	bitsPerPage = bitsPerByte * pageSize
 )
 
+// layout tells how bits are spread over pages
+type layout struct {
+	pageSize    int
+	bitsPerPage int
+}
+
+// newLayout rounds pageSize up to whole 64 bits words, zero means the default
+func newLayout(size int) layout {
+	if size <= 0 {
+		size = pageSize
+	}
+
+	size = (size + 7) / 8 * 8
+
+	return layout{
+		pageSize:    size,
+		bitsPerPage: bitsPerByte * size,
+	}
+}
+
+// pageOf returns the id of the page holding bit n
+func (l layout) pageOf(n uint64) uint64 {
+	return n / uint64(l.bitsPerPage)
+}
+
+// offsetOf returns the offset of bit n inside its page
+func (l layout) offsetOf(n uint64) int {
+	return int(n % uint64(l.bitsPerPage))
+}
+
+// position returns the bit at offset o of the page id
+func (l layout) position(id uint64, o int) uint64 {
+	return id*uint64(l.bitsPerPage) + uint64(o)
+}
+
 // one page is the minimal unit for managing lot's of bits
 type page struct {
-	id   int
+	id   uint64
 	size int
 	bits []uint8
 }
@@ -45,8 +94,9 @@ func New(option *Option) *Bitmap {
 		}
 	}
 	b := &Bitmap{
+		layout: newLayout(option.PageSize),
 		option: option.clone(),
-		pages:  list.New(),
+		pages:  nil,
 		size:   0,
 	}
 
@@ -54,115 +104,142 @@ func New(option *Option) *Bitmap {
 }
 
 // alloc one new page
-func (b *Bitmap) newPage(id int) *page {
+func (b *Bitmap) newPage(id uint64) *page {
 	p := &page{
 		id:   id,
-		bits: make([]byte, pageSize),
+		bits: make([]byte, b.pageSize),
 	}
 
 	return p
 }
 
-func (b *Bitmap) getPageIndex(n int) int {
-	if n%bitsPerPage != 0 {
-		return n / bitsPerPage
-	} else {
-		return n/bitsPerPage - 1
-	}
+// bitsLen returns how many bits the page holds
+func (p *page) bitsLen() int {
+	return len(p.bits) * bitsPerByte
 }
 
-func (b *Bitmap) getPage(n int, create bool) *list.Element {
-	if n > b.option.Capacity && !b.option.AutoExpand {
-		return nil
-	}
-
-	pageIdx := b.getPageIndex(n)
+// inRange returns whether bit n can be stored in this bitmap
+func (b *Bitmap) inRange(n uint64) bool {
+	return b.option.AutoExpand || n < b.option.Capacity
+}
 
-	for e := b.pages.Front(); e != nil; {
-		if e.Value.(*page).id == pageIdx {
-			return e
-		}
+// searchPage returns the position of the page id in b.pages,
+// or the position to insert it if the page doesn't exist
+func (b *Bitmap) searchPage(id uint64) (int, bool) {
+	i := sort.Search(len(b.pages), func(i int) bool {
+		return b.pages[i].id >= id
+	})
 
-		if e.Next() != nil && e.Next().Value.(*page).id > pageIdx {
-			if !create {
-				return nil
-			}
+	return i, i < len(b.pages) && b.pages[i].id == id
+}
 
-			page := b.newPage(pageIdx)
-			b.pages.InsertAfter(page, e)
-			return e.Next()
+func (b *Bitmap) getPage(n uint64, create bool) (int, *page) {
+	if !b.inRange(n) {
+		return -1, nil
+	}
 
-		}
+	pageIdx := b.pageOf(n)
 
-		e = e.Next()
+	i, ok := b.searchPage(pageIdx)
+	if ok {
+		return i, b.pages[i]
 	}
 
 	if !create {
-		return nil
+		return -1, nil
 	}
 
-	page := b.newPage(pageIdx)
-	b.pages.PushBack(page)
+	b.pages = append(b.pages, nil)
+	copy(b.pages[i+1:], b.pages[i:])
+	b.pages[i] = b.newPage(pageIdx)
+
+	return i, b.pages[i]
+}
 
-	return b.pages.Back()
+// removePage drops the page at position i of b.pages
+func (b *Bitmap) removePage(i int) {
+	copy(b.pages[i:], b.pages[i+1:])
+	b.pages[len(b.pages)-1] = nil
+	b.pages = b.pages[:len(b.pages)-1]
 }
 
-func (b *Bitmap) setBitInPage(n int, set bool) {
-	e := b.getPage(n, true)
-	if e == nil {
-		// silently ignored this request
-		return
+// setBitInPage reports whether the bit changed
+func (b *Bitmap) setBitInPage(n uint64, set bool) bool {
+	// Clearing a bit never needs a new page
+	i, page := b.getPage(n, set)
+	if page == nil {
+		return false
 	}
 
-	page := e.Value.(*page)
+	idx := b.offsetOf(n) / bitsPerByte
+	mask := uint8(1) << uint8(b.offsetOf(n)%bitsPerByte)
+
+	if (page.bits[idx]&mask != 0) == set {
+		return false
+	}
 
-	idx := (n - 1) % bitsPerPage / bitsPerByte
 	if set {
-		page.bits[idx] |= 1 << uint8((n-1)%bitsPerPage%bitsPerByte)
+		page.bits[idx] |= mask
 		page.size++
 	} else {
-		page.bits[idx] &^= 1 << uint8((n-1)%bitsPerPage%bitsPerByte)
+		page.bits[idx] &^= mask
 		page.size--
 
 		if page.size == 0 && b.option.AutoRecycle {
-			b.pages.Remove(e)
+			b.removePage(i)
 		}
 
 	}
+
+	return true
 }
 
 // Test whether one bit is set or not
-func (b *Bitmap) Test(n int) bool {
+func (b *Bitmap) Test(n uint64) bool {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	e := b.getPage(n, false)
-	if e == nil {
+	_, page := b.getPage(n, false)
+	if page == nil {
 		return false
 	}
 
-	page := e.Value.(*page)
-
-	idx := (n - 1) % bitsPerPage / bitsPerByte
-	return page.bits[idx]&(1<<uint8((n-1)%bitsPerPage%bitsPerByte)) > 0
+	idx := b.offsetOf(n) / bitsPerByte
+	return page.bits[idx]&(1<<uint8(b.offsetOf(n)%bitsPerByte)) > 0
 }
 
-// Clear one bit
-func (b *Bitmap) Clear(n int) {
+// Clear one bit, reports whether it was set before
+func (b *Bitmap) Clear(n uint64) (bool, error) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	b.setBitInPage(n, false)
+	if !b.inRange(n) {
+		return false, ErrOutOfRange
+	}
+
+	if !b.setBitInPage(n, false) {
+		return false, nil
+	}
+
 	b.size--
+	return true, nil
 }
 
-// Set one bit
-func (b *Bitmap) Set(n int) {
+// Set one bit, reports whether it wasn't set before
+func (b *Bitmap) Set(n uint64) (bool, error) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	b.setBitInPage(n, true)
+	if !b.inRange(n) {
+		return false, ErrOutOfRange
+	}
+
+	if !b.setBitInPage(n, true) {
+		return false, nil
+	}
+
 	b.size++
+	return true, nil
 }
 
 // Reinit the whole Bitmap
@@ -170,11 +247,7 @@ func (b *Bitmap) ClearAll() {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	var p *page
-
-	for e := b.pages.Front(); e != nil; e = e.Next() {
-		p = e.Value.(*page)
-
+	for _, p := range b.pages {
 		// Actually I just want memset, painful, waiting for new Go release
 		for i := range p.bits {
 			p.bits[i] = 0
@@ -189,7 +262,7 @@ func (b *Bitmap) ClearAll() {
 }
 
 // Total count of bits setted
-func (b *Bitmap) Size() int {
+func (b *Bitmap) Size() uint64 {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
@@ -201,22 +274,25 @@ func (b *Bitmap) Gc() {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	for e := b.pages.Front(); e != nil; {
+	pages := b.pages[:0]
 
-		if e.Value.(*page).size == 0 {
-			old := e.Next()
-			b.pages.Remove(e)
-			e = old
-		} else {
-			e = e.Next()
+	for _, p := range b.pages {
+		if p.size != 0 {
+			pages = append(pages, p)
 		}
+	}
 
+	for i := len(pages); i < len(b.pages); i++ {
+		b.pages[i] = nil
 	}
 
+	b.pages = pages
+
 }
 
-// How many bits can be set in this bitmap
-func (b *Bitmap) Capacity() int {
+// How many bits can be set in this bitmap. Once the last possible page is
+// allocated, the real capacity of 1<<64 is reported as math.MaxUint64.
+func (b *Bitmap) Capacity() uint64 {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
@@ -224,10 +300,14 @@ func (b *Bitmap) Capacity() int {
 		return b.option.Capacity
 	}
 
-	e := b.pages.Back()
-	if e == nil {
+	if len(b.pages) == 0 {
 		return 0
 	}
 
-	return (e.Value.(*page).id + 1) * bitsPerPage
+	last := b.pages[len(b.pages)-1].id
+	if last == b.pageOf(math.MaxUint64) {
+		return math.MaxUint64
+	}
+
+	return b.position(last+1, 0)
 }
@@ -5,9 +5,18 @@
 package bitmap
 
 import (
+	"container/list"
+	"math"
+	"math/rand"
 	"testing"
 )
 
+// position returns the bit at offset o of the page id, with the default
+// page size
+func position(id uint64, o int) uint64 {
+	return newLayout(0).position(id, o)
+}
+
 func TestBitmap(t *testing.T) {
 	bm := New(nil)
 
@@ -17,7 +26,7 @@ func TestBitmap(t *testing.T) {
 		t.Fatal("Bitmap should has one element")
 	}
 
-	if bm.Capacity() != bitsPerPage {
+	if bm.Capacity() != uint64(bitsPerPage) {
 		t.Fatal("Capacity should be 4K * 8(default)")
 	}
 
@@ -28,11 +37,11 @@ func TestBitmap(t *testing.T) {
 
 	bm.Set(10)
 	bm.ClearAll()
-	if bm.Capacity() != bitsPerPage {
+	if bm.Capacity() != uint64(bitsPerPage) {
 		t.Fatal("Clear action should cleared the whole memory")
 	}
 
-	for i := 1; i < 150000; i++ {
+	for i := uint64(0); i < 150000; i++ {
 		bm.Set(i)
 
 		if !bm.Test(i) {
@@ -45,7 +54,7 @@ func TestBitmap(t *testing.T) {
 
 	}
 
-	for i := 1; i < 150000; i++ {
+	for i := uint64(0); i < 150000; i++ {
 		bm.Clear(i)
 
 		if bm.Test(i) {
@@ -73,15 +82,60 @@ func TestBitMapAutoExpandDisabled(t *testing.T) {
 		AutoRecycle: false,
 	})
 
-	bm.Set(100) // Should fail at this step
-	if bm.Test(100) {
+	// Should fail at this step
+	if _, err := bm.Set(100); err != ErrOutOfRange || bm.Test(100) {
 		t.Fatal("autoexpand should be disabled")
 	}
 
+	if _, err := bm.Set(10); err != ErrOutOfRange {
+		t.Fatal("10 should be beyond the capacity")
+	}
+
+	if _, err := bm.Clear(10); err != ErrOutOfRange {
+		t.Fatal("10 should be beyond the capacity")
+	}
+
 	bm.Set(4)
 	if !bm.Test(4) {
 		t.Fatal("4 should in this bitmap")
 	}
+
+	if ok, err := bm.Set(9); !ok || err != nil || !bm.Test(9) {
+		t.Fatal("9 should in this bitmap")
+	}
+}
+
+func TestBitMapFullRange(t *testing.T) {
+	bm := New(nil)
+
+	for _, n := range []uint64{0, math.MaxUint32 + 1, math.MaxUint64} {
+		if ok, err := bm.Set(n); !ok || err != nil || !bm.Test(n) {
+			t.Fatalf("%d should in this bitmap", n)
+		}
+	}
+
+	if bm.Size() != 3 || bm.Test(1) || bm.Test(math.MaxUint64-1) {
+		t.Fatal("Only the bits set should be found")
+	}
+
+	if bm.Capacity() != math.MaxUint64 {
+		t.Fatal("Capacity should cover the whole range")
+	}
+
+	if n, ok := bm.PrevSet(math.MaxUint64 - 1); !ok || n != math.MaxUint32+1 {
+		t.Fatal("PrevSet should skip to the middle page")
+	}
+
+	it := bm.Iterator()
+	for _, e := range []uint64{0, math.MaxUint32 + 1, math.MaxUint64} {
+		if n, ok := it.Next(); !ok || n != e {
+			t.Fatalf("Iterator should return %d", e)
+		}
+	}
+
+	if _, ok := it.Next(); ok {
+		t.Fatal("Iterator should stop at the end of the range")
+	}
 }
 
 func TestBitMapAutoExpandEnabled(t *testing.T) {
@@ -108,7 +162,7 @@ func TestBitMapAutoRecycleEnabled(t *testing.T) {
 	bm.Set(100000)
 	bm.Clear(100000)
 
-	if bm.pages.Len() != 0 {
+	if len(bm.pages) != 0 {
 		t.Fatal("Autorecycle doesn't work")
 	}
 
@@ -124,14 +178,240 @@ func TestBitMapAutoRecycleDisabled(t *testing.T) {
 	bm.Set(100000)
 	bm.Clear(100000)
 
-	if bm.pages.Len() != 1 {
+	if len(bm.pages) != 1 {
 		t.Fatal("Autorecycle doesn't work")
 	}
 
 	bm.Gc()
 
-	if bm.pages.Len() != 0 {
+	if len(bm.pages) != 0 {
 		t.Fatal("Failed to run GC")
 	}
 
 }
+
+func TestBitMapPageOrder(t *testing.T) {
+	bm := New(nil)
+
+	for _, i := range []uint64{5, 1, 9, 3, 7} {
+		bm.Set(position(i, 1))
+	}
+
+	for i := 1; i < len(bm.pages); i++ {
+		if bm.pages[i-1].id >= bm.pages[i].id {
+			t.Fatal("Pages should be kept sorted")
+		}
+	}
+
+	bm.Clear(position(5, 1))
+
+	if len(bm.pages) != 4 || bm.Test(position(5, 1)) || !bm.Test(position(7, 1)) {
+		t.Fatal("Failed to recycle a page in the middle")
+	}
+
+	if bm.Capacity() != position(10, 0) {
+		t.Fatal("Capacity should follow the last page")
+	}
+}
+
+const benchPages = 1000000
+
+// benchPageIds returns the ids looked up by the page benchmarks, half of
+// them are missing from the bitmap
+func benchPageIds() []uint64 {
+	r := rand.New(rand.NewSource(1))
+
+	ids := make([]uint64, 1024)
+	for i := range ids {
+		ids[i] = uint64(r.Intn(2 * benchPages))
+	}
+
+	return ids
+}
+
+// Pages hold no bits here, a million real pages would take gigabytes
+func BenchmarkGetPage(b *testing.B) {
+	bm := New(nil)
+
+	for i := 0; i < benchPages; i++ {
+		bm.pages = append(bm.pages, &page{id: uint64(2 * i)})
+	}
+
+	ids := benchPageIds()
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		bm.getPage(position(ids[i%len(ids)], 0), false)
+	}
+}
+
+// BenchmarkGetPageList walks a linked list of pages the way the lookup
+// worked before pages were kept in a sorted slice
+func BenchmarkGetPageList(b *testing.B) {
+	pages := list.New()
+
+	for i := 0; i < benchPages; i++ {
+		pages.PushBack(&page{id: uint64(2 * i)})
+	}
+
+	ids := benchPageIds()
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		id := ids[i%len(ids)]
+
+		for e := pages.Front(); e != nil && e.Value.(*page).id <= id; e = e.Next() {
+			if e.Value.(*page).id == id {
+				break
+			}
+		}
+	}
+}
+
+func TestBitMapIdempotent(t *testing.T) {
+	bm := New(nil)
+
+	if first, _ := bm.Set(10); !first {
+		t.Fatal("Only the first Set should change the bit")
+	}
+
+	if second, _ := bm.Set(10); second {
+		t.Fatal("Only the first Set should change the bit")
+	}
+
+	bm.Set(11)
+
+	if first, _ := bm.Clear(10); !first {
+		t.Fatal("Only the first Clear should change the bit")
+	}
+
+	if second, _ := bm.Clear(10); second {
+		t.Fatal("Only the first Clear should change the bit")
+	}
+
+	if bm.Size() != 1 || len(bm.pages) != 1 || !bm.Test(11) {
+		t.Fatal("Clearing twice should not recycle a page still in use")
+	}
+
+	if ok, _ := bm.Clear(position(1, 0)); ok || len(bm.pages) != 1 {
+		t.Fatal("Clearing an unset bit should not alloc a page")
+	}
+}
+
+// checkCounts verifies the cached counts against the bits really set
+func checkCounts(t *testing.T, bm *Bitmap) {
+	total := 0
+
+	for _, p := range bm.pages {
+		if p.size != popcount(p.bits) {
+			t.Fatalf("Page %d counts %d bits but holds %d", p.id, p.size, popcount(p.bits))
+		}
+
+		total += p.size
+	}
+
+	if uint64(total) != bm.size {
+		t.Fatalf("Bitmap counts %d bits but holds %d", bm.size, total)
+	}
+}
+
+func TestBitMapRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	for _, option := range []*Option{
+		nil,
+		{AutoExpand: true, AutoRecycle: false},
+		{AutoExpand: false, AutoRecycle: true, Capacity: position(2, 0)},
+	} {
+		bm := New(option)
+		model := make(map[uint64]bool)
+		limit := position(4, 0)
+
+		for i := 0; i < 100000; i++ {
+			n := uint64(r.Int63n(int64(limit)))
+
+			// Keep the bits in a few pages so they are often reused
+			if r.Intn(2) == 0 {
+				n = position(uint64(r.Intn(4)), r.Intn(256))
+			}
+
+			valid := option == nil || option.AutoExpand || n < option.Capacity
+
+			switch r.Intn(2) {
+			case 0:
+				changed, err := bm.Set(n)
+				if changed != (valid && !model[n]) || (err == nil) != valid {
+					t.Fatalf("Set(%d) reported %v, %v", n, changed, err)
+				}
+				if valid {
+					model[n] = true
+				}
+			default:
+				changed, err := bm.Clear(n)
+				if changed != model[n] || (err == nil) != valid {
+					t.Fatalf("Clear(%d) reported %v, %v", n, changed, err)
+				}
+				delete(model, n)
+			}
+		}
+
+		checkCounts(t, bm)
+
+		if bm.Size() != uint64(len(model)) {
+			t.Fatalf("Size %d should equal to %d", bm.Size(), len(model))
+		}
+
+		for n := uint64(0); n < limit; n++ {
+			if bm.Test(n) != model[n] {
+				t.Fatalf("%d mismatched", n)
+			}
+		}
+	}
+}
+
+func TestBitMapPageSize(t *testing.T) {
+	bm := New(&Option{
+		AutoExpand:  true,
+		AutoRecycle: true,
+		PageSize:    3, // rounded up to 8 bytes
+	})
+
+	if bm.pageSize != 8 || bm.bitsPerPage != 64 {
+		t.Fatal("Page size should be rounded up to 64 bits")
+	}
+
+	bm.Set(100)
+
+	if len(bm.pages) != 1 || len(bm.pages[0].bits) != 8 || bm.pages[0].id != 1 {
+		t.Fatal("Page should follow the page size")
+	}
+
+	if bm.Capacity() != 128 {
+		t.Fatal("Capacity should follow the page size")
+	}
+
+	bm.SetRange(10, 1000)
+
+	if len(bm.pages) != 16 || bm.Size() != 991 || bm.CountRange(64, 127) != 64 {
+		t.Fatal("Ranges should follow the page size")
+	}
+
+	if n, _ := bm.Select(100); n != 110 || bm.Rank(1000) != 991 {
+		t.Fatal("Rank and Select should follow the page size")
+	}
+
+	if n, _ := bm.PrevSet(5000); n != 1000 {
+		t.Fatal("PrevSet should follow the page size")
+	}
+
+	checkCounts(t, bm)
+
+	big := New(&Option{AutoExpand: true, PageSize: 1 << 16})
+	big.Set(1 << 20)
+
+	if big.Capacity() != 1<<20+1<<19 {
+		t.Fatal("Capacity should follow the page size")
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bitmap
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// A ConcurrentBitmap can be used from many goroutines without any global
+// lock. Pages are looked up through a sync.Map, which doesn't lock once a
+// page exists, and bits are changed with atomic operations on 64 bits words.
+//
+// Pages are never recycled, AutoRecycle of the option is ignored.
+type ConcurrentBitmap struct {
+	layout
+
+	size   uint64   // total elements stored, accessed atomically
+	pages  sync.Map // page id -> *atomicPage
+	option *Option
+}
+
+type atomicPage struct {
+	words []uint64
+}
+
+// Return back a new ConcurrentBitmap according the option passed in
+func NewConcurrent(option *Option) *ConcurrentBitmap {
+	if option == nil {
+		option = &Option{
+			AutoExpand: true,
+		}
+	}
+
+	return &ConcurrentBitmap{
+		layout: newLayout(option.PageSize),
+		option: option.clone(),
+	}
+}
+
+func (b *ConcurrentBitmap) inRange(n uint64) bool {
+	return b.option.AutoExpand || n < b.option.Capacity
+}
+
+func (b *ConcurrentBitmap) getPage(n uint64, create bool) *atomicPage {
+	if v, ok := b.pages.Load(b.pageOf(n)); ok {
+		return v.(*atomicPage)
+	}
+
+	if !create {
+		return nil
+	}
+
+	// Losing the race only wastes one allocation
+	v, _ := b.pages.LoadOrStore(b.pageOf(n), &atomicPage{
+		words: make([]uint64, b.bitsPerPage/bitsPerWord),
+	})
+
+	return v.(*atomicPage)
+}
+
+// word returns the word holding offset o and the mask of o inside it
+func (p *atomicPage) word(o int) (*uint64, uint64) {
+	return &p.words[o/bitsPerWord], 1 << uint(o%bitsPerWord)
+}
+
+// Test whether one bit is set or not
+func (b *ConcurrentBitmap) Test(n uint64) bool {
+	p := b.getPage(n, false)
+	if p == nil {
+		return false
+	}
+
+	w, mask := p.word(b.offsetOf(n))
+	return atomic.LoadUint64(w)&mask != 0
+}
+
+// TestAndSet sets one bit and returns whether it was already set. Among
+// goroutines setting the same bit, exactly one sees false.
+func (b *ConcurrentBitmap) TestAndSet(n uint64) (bool, error) {
+	if !b.inRange(n) {
+		return false, ErrOutOfRange
+	}
+
+	w, mask := b.getPage(n, true).word(b.offsetOf(n))
+
+	for {
+		old := atomic.LoadUint64(w)
+		if old&mask != 0 {
+			return true, nil
+		}
+
+		if atomic.CompareAndSwapUint64(w, old, old|mask) {
+			atomic.AddUint64(&b.size, 1)
+			return false, nil
+		}
+	}
+}
+
+// TestAndClear clears one bit and returns whether it was set. Among
+// goroutines clearing the same bit, exactly one sees true.
+func (b *ConcurrentBitmap) TestAndClear(n uint64) (bool, error) {
+	if !b.inRange(n) {
+		return false, ErrOutOfRange
+	}
+
+	p := b.getPage(n, false)
+	if p == nil {
+		return false, nil
+	}
+
+	w, mask := p.word(b.offsetOf(n))
+
+	for {
+		old := atomic.LoadUint64(w)
+		if old&mask == 0 {
+			return false, nil
+		}
+
+		if atomic.CompareAndSwapUint64(w, old, old&^mask) {
+			atomic.AddUint64(&b.size, ^uint64(0))
+			return true, nil
+		}
+	}
+}
+
+// Set one bit, reports whether it wasn't set before
+func (b *ConcurrentBitmap) Set(n uint64) (bool, error) {
+	set, err := b.TestAndSet(n)
+	return !set && err == nil, err
+}
+
+// Clear one bit, reports whether it was set before
+func (b *ConcurrentBitmap) Clear(n uint64) (bool, error) {
+	return b.TestAndClear(n)
+}
+
+// Total count of bits setted
+func (b *ConcurrentBitmap) Size() uint64 {
+	return atomic.LoadUint64(&b.size)
+}
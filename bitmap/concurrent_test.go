@@ -0,0 +1,164 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bitmap
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestConcurrentBitmap(t *testing.T) {
+	b := NewConcurrent(nil)
+
+	for _, n := range []uint64{0, 63, 64, position(5, 7)} {
+		if ok, err := b.Set(n); !ok || err != nil || !b.Test(n) {
+			t.Fatalf("%d should in this bitmap", n)
+		}
+
+		if ok, _ := b.Set(n); ok {
+			t.Fatal("Setting twice should not change the bit")
+		}
+	}
+
+	if b.Size() != 4 || b.Test(1) || b.Test(position(4, 7)) {
+		t.Fatal("Only the bits set should be found")
+	}
+
+	if ok, _ := b.Clear(63); !ok || b.Test(63) || b.Size() != 3 {
+		t.Fatal("Failed to clear one bit")
+	}
+
+	if ok, _ := b.Clear(position(9, 0)); ok {
+		t.Fatal("Clearing an unset bit should not change the bit")
+	}
+
+	limited := NewConcurrent(&Option{Capacity: 10})
+	if _, err := limited.TestAndSet(10); err != ErrOutOfRange {
+		t.Fatal("10 should be beyond the capacity")
+	}
+}
+
+// Every goroutine tries to claim every slot, each slot must be won once
+func TestConcurrentBitmapClaim(t *testing.T) {
+	const (
+		goroutines = 8
+		slots      = 10000
+	)
+
+	b := NewConcurrent(nil)
+
+	var claimed, released uint64
+
+	run := func(f func(g int)) {
+		var wg sync.WaitGroup
+
+		for g := 0; g < goroutines; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				f(g)
+			}(g)
+		}
+
+		wg.Wait()
+	}
+
+	run(func(g int) {
+		for i := 0; i < slots; i++ {
+			// Spread the slots over several pages
+			n := uint64((i*7919 + g) % slots * 97)
+
+			if set, _ := b.TestAndSet(n); !set {
+				atomic.AddUint64(&claimed, 1)
+			}
+		}
+	})
+
+	run(func(g int) {
+		for i := 0; i < slots; i++ {
+			if set, _ := b.TestAndClear(uint64((i + g) % slots * 97)); set {
+				atomic.AddUint64(&released, 1)
+			}
+		}
+	})
+
+	if claimed != slots || released != slots {
+		t.Fatalf("%d slots claimed and %d released, expected %d", claimed, released, slots)
+	}
+
+	if b.Size() != 0 {
+		t.Fatal("Every slot should be released")
+	}
+}
+
+func BenchmarkConcurrentBitmapSet(b *testing.B) {
+	bm := NewConcurrent(nil)
+
+	b.RunParallel(func(pb *testing.PB) {
+		n := uint64(0)
+		for pb.Next() {
+			bm.Set(n % (1 << 20))
+			n += 4099
+		}
+	})
+}
+
+func BenchmarkBitmapSetParallel(b *testing.B) {
+	bm := New(nil)
+
+	b.RunParallel(func(pb *testing.PB) {
+		n := uint64(0)
+		for pb.Next() {
+			bm.Set(n % (1 << 20))
+			n += 4099
+		}
+	})
+}
+
+func BenchmarkConcurrentBitmapTest(b *testing.B) {
+	bm := NewConcurrent(nil)
+	for n := uint64(0); n < 1<<20; n += 3 {
+		bm.Set(n)
+	}
+
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		n := uint64(0)
+		for pb.Next() {
+			bm.Test(n % (1 << 20))
+			n += 4099
+		}
+	})
+}
+
+func BenchmarkBitmapTestParallel(b *testing.B) {
+	bm := New(nil)
+	for n := uint64(0); n < 1<<20; n += 3 {
+		bm.Set(n)
+	}
+
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		n := uint64(0)
+		for pb.Next() {
+			bm.Test(n % (1 << 20))
+			n += 4099
+		}
+	})
+}
+
+func TestConcurrentBitmapPageSize(t *testing.T) {
+	b := NewConcurrent(&Option{AutoExpand: true, PageSize: 16})
+
+	b.Set(300)
+
+	v, ok := b.pages.Load(uint64(2))
+	if !ok || len(v.(*atomicPage).words) != 2 || !b.Test(300) {
+		t.Fatal("Pages should follow the page size")
+	}
+}
@@ -0,0 +1,372 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+// +build linux
+
+package bitmap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// A FileBitmap keeps its pages in a memory-mapped file, so its content
+// survives restarts without being loaded.
+//
+// The file starts with a header, followed by slots appended as pages get
+// allocated. Each slot holds the id and the count of bits of one page,
+// then the bits laid out as in a Bitmap page.
+//
+//	header: magic[8] version[4] pageSize[4] slots[8] size[8]
+//	slot:   id[8] size[8] bits[pageSize]
+//
+// The page size is stored in the header, PageSize of the option only
+// applies to new files. Pages are never recycled, clearing a whole page
+// leaves it in the file.
+type FileBitmap struct {
+	mu sync.Mutex
+	layout
+
+	f      *os.File
+	data   []uint8    // the whole file, mapped
+	slots  int        // slots the file can hold without growing
+	pages  []filePage // sorted by id
+	option *Option
+}
+
+type filePage struct {
+	*page
+	slot int
+}
+
+const (
+	fileMagic   = "COCONUTB"
+	fileVersion = 1
+
+	fileHeaderSize = 32
+	slotHeaderSize = 16
+
+	// offsets of the header fields updated in place
+	headerSlots = 16
+	headerSize  = 24
+)
+
+func (b *FileBitmap) slotSize() int {
+	return slotHeaderSize + b.pageSize
+}
+
+func (b *FileBitmap) slotOffset(slot int) int {
+	return fileHeaderSize + slot*b.slotSize()
+}
+
+// OpenFile opens the bitmap stored in the named file, creating it if
+// needed. Except PageSize the option isn't stored in the file, AutoRecycle
+// is ignored.
+func OpenFile(name string, option *Option) (*FileBitmap, error) {
+	if option == nil {
+		option = &Option{
+			AutoExpand: true,
+		}
+	}
+
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &FileBitmap{
+		f:      f,
+		option: option.clone(),
+	}
+
+	if err := b.load(); err != nil {
+		b.Close()
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// load maps the file and indexes its pages, only slot headers are read
+func (b *FileBitmap) load() error {
+	fi, err := b.f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if fi.Size() == 0 {
+		b.layout = newLayout(b.option.PageSize)
+
+		if err := b.remap(1); err != nil {
+			return err
+		}
+
+		copy(b.data, fileMagic)
+		binary.LittleEndian.PutUint32(b.data[8:], fileVersion)
+		binary.LittleEndian.PutUint32(b.data[12:], uint32(b.pageSize))
+		return nil
+	}
+
+	header := make([]uint8, fileHeaderSize)
+	if _, err := b.f.ReadAt(header, 0); err != nil {
+		return ErrInvalidFormat
+	}
+
+	size := int(binary.LittleEndian.Uint32(header[12:]))
+
+	if !bytes.Equal(header[:8], []byte(fileMagic)) ||
+		binary.LittleEndian.Uint32(header[8:]) != fileVersion ||
+		size == 0 || size%8 != 0 {
+		return ErrInvalidFormat
+	}
+
+	b.layout = newLayout(size)
+
+	if err := b.remap(int((fi.Size() - fileHeaderSize) / int64(b.slotSize()))); err != nil {
+		return err
+	}
+
+	used := binary.LittleEndian.Uint64(b.data[headerSlots:])
+	if used > uint64(b.slots) {
+		return ErrInvalidFormat
+	}
+
+	for slot := 0; slot < int(used); slot++ {
+		off := b.slotOffset(slot)
+
+		p := &page{
+			id:   binary.LittleEndian.Uint64(b.data[off:]),
+			size: int(binary.LittleEndian.Uint64(b.data[off+8:])),
+		}
+
+		if p.size > b.bitsPerPage {
+			return ErrInvalidFormat
+		}
+
+		b.pages = append(b.pages, filePage{p, slot})
+	}
+
+	sort.Slice(b.pages, func(i, j int) bool {
+		return b.pages[i].id < b.pages[j].id
+	})
+
+	for i := 1; i < len(b.pages); i++ {
+		if b.pages[i-1].id == b.pages[i].id {
+			return ErrInvalidFormat
+		}
+	}
+
+	b.bind()
+
+	return nil
+}
+
+// remap resizes the file to hold the given count of slots and maps it again
+func (b *FileBitmap) remap(slots int) error {
+	if b.data != nil {
+		if err := syscall.Munmap(b.data); err != nil {
+			return err
+		}
+		b.data = nil
+	}
+
+	size := b.slotOffset(slots)
+
+	if err := b.f.Truncate(int64(size)); err != nil {
+		return err
+	}
+
+	data, err := syscall.Mmap(int(b.f.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return err
+	}
+
+	b.data = data
+	b.slots = slots
+
+	return nil
+}
+
+// bind points the bits of every page into the current mapping
+func (b *FileBitmap) bind() {
+	for _, p := range b.pages {
+		off := b.slotOffset(p.slot) + slotHeaderSize
+		p.bits = b.data[off : off+b.pageSize : off+b.pageSize]
+	}
+}
+
+func (b *FileBitmap) header(field int) uint64 {
+	return binary.LittleEndian.Uint64(b.data[field:])
+}
+
+func (b *FileBitmap) setHeader(field int, v uint64) {
+	binary.LittleEndian.PutUint64(b.data[field:], v)
+}
+
+func (b *FileBitmap) inRange(n uint64) bool {
+	return b.option.AutoExpand || n < b.option.Capacity
+}
+
+func (b *FileBitmap) searchPage(id uint64) (int, bool) {
+	i := sort.Search(len(b.pages), func(i int) bool {
+		return b.pages[i].id >= id
+	})
+
+	return i, i < len(b.pages) && b.pages[i].id == id
+}
+
+// getPage returns the page holding bit n, allocating a slot if asked.
+// The file doubles when it runs out of slots.
+func (b *FileBitmap) getPage(n uint64, create bool) (filePage, error) {
+	i, ok := b.searchPage(b.pageOf(n))
+	if ok {
+		return b.pages[i], nil
+	}
+
+	if !create {
+		return filePage{}, nil
+	}
+
+	slot := int(b.header(headerSlots))
+	if slot == b.slots {
+		slots := 2 * b.slots
+		if slots == 0 {
+			slots = 1
+		}
+
+		if err := b.remap(slots); err != nil {
+			return filePage{}, err
+		}
+		b.bind()
+	}
+
+	off := b.slotOffset(slot)
+	binary.LittleEndian.PutUint64(b.data[off:], b.pageOf(n))
+	b.setHeader(headerSlots, uint64(slot+1))
+
+	p := filePage{
+		page: &page{
+			id:   b.pageOf(n),
+			bits: b.data[off+slotHeaderSize : off+b.slotSize() : off+b.slotSize()],
+		},
+		slot: slot,
+	}
+
+	b.pages = append(b.pages, filePage{})
+	copy(b.pages[i+1:], b.pages[i:])
+	b.pages[i] = p
+
+	return p, nil
+}
+
+func (b *FileBitmap) setBit(n uint64, set bool) (bool, error) {
+	if !b.inRange(n) {
+		return false, ErrOutOfRange
+	}
+
+	p, err := b.getPage(n, set)
+	if err != nil || p.page == nil {
+		return false, err
+	}
+
+	idx := b.offsetOf(n) / bitsPerByte
+	mask := uint8(1) << uint8(b.offsetOf(n)%bitsPerByte)
+
+	if (p.bits[idx]&mask != 0) == set {
+		return false, nil
+	}
+
+	size := b.header(headerSize)
+
+	if set {
+		p.bits[idx] |= mask
+		p.size++
+		size++
+	} else {
+		p.bits[idx] &^= mask
+		p.size--
+		size--
+	}
+
+	binary.LittleEndian.PutUint64(b.data[b.slotOffset(p.slot)+8:], uint64(p.size))
+	b.setHeader(headerSize, size)
+
+	return true, nil
+}
+
+// Test whether one bit is set or not
+func (b *FileBitmap) Test(n uint64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	p, _ := b.getPage(n, false)
+	if p.page == nil {
+		return false
+	}
+
+	return p.bits[b.offsetOf(n)/bitsPerByte]&(1<<uint8(b.offsetOf(n)%bitsPerByte)) > 0
+}
+
+// Set one bit, reports whether it wasn't set before
+func (b *FileBitmap) Set(n uint64) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.setBit(n, true)
+}
+
+// Clear one bit, reports whether it was set before
+func (b *FileBitmap) Clear(n uint64) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.setBit(n, false)
+}
+
+// Total count of bits setted
+func (b *FileBitmap) Size() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.header(headerSize)
+}
+
+// Sync flushes the mapped pages and the file size to the disk
+func (b *FileBitmap) Sync() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	_, _, errno := syscall.Syscall(syscall.SYS_MSYNC,
+		uintptr(unsafe.Pointer(&b.data[0])), uintptr(len(b.data)), syscall.MS_SYNC)
+	if errno != 0 {
+		return errno
+	}
+
+	return b.f.Sync()
+}
+
+// Close unmaps and closes the file, without syncing it
+func (b *FileBitmap) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var err error
+
+	if b.data != nil {
+		err = syscall.Munmap(b.data)
+		b.data = nil
+	}
+
+	if cerr := b.f.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
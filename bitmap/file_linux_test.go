@@ -0,0 +1,140 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+// +build linux
+
+package bitmap
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileBitmap(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "bitmap")
+
+	b, err := OpenFile(name, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ns := []uint64{0, 10, position(1, 0), position(7, 3), math.MaxUint64}
+
+	for _, n := range ns {
+		if ok, err := b.Set(n); !ok || err != nil {
+			t.Fatalf("Failed to set %d", n)
+		}
+	}
+
+	if ok, _ := b.Set(10); ok {
+		t.Fatal("Setting twice should not change the bit")
+	}
+
+	if ok, _ := b.Clear(11); ok {
+		t.Fatal("Clearing an unset bit should not change the bit")
+	}
+
+	if b.Size() != uint64(len(ns)) || b.slots < len(b.pages) || len(b.pages) != 4 {
+		t.Fatal("Pages should be allocated in the file")
+	}
+
+	if err := b.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, _ := os.Stat(name)
+	if fi.Size() != int64(b.slotOffset(4)) {
+		t.Fatal("File should have grown to four slots")
+	}
+
+	b, err = OpenFile(name, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if b.Size() != uint64(len(ns)) {
+		t.Fatal("Size should survive a restart")
+	}
+
+	for _, n := range ns {
+		if !b.Test(n) {
+			t.Fatalf("%d should survive a restart", n)
+		}
+	}
+
+	if b.Test(11) || b.Test(position(2, 0)) {
+		t.Fatal("Unset bits should stay unset")
+	}
+
+	b.Clear(10)
+	b.Set(position(3, 0))
+
+	if b.Size() != uint64(len(ns)) || b.Test(10) || !b.Test(position(3, 0)) {
+		t.Fatal("Reopened bitmap should be writable")
+	}
+}
+
+func TestFileBitmapOption(t *testing.T) {
+	b, err := OpenFile(filepath.Join(t.TempDir(), "bitmap"), &Option{Capacity: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if _, err := b.Set(10); err != ErrOutOfRange {
+		t.Fatal("10 should be beyond the capacity")
+	}
+
+	if ok, err := b.Set(9); !ok || err != nil {
+		t.Fatal("9 should in this bitmap")
+	}
+}
+
+func TestFileBitmapInvalid(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "bitmap")
+
+	if err := os.WriteFile(name, make([]byte, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenFile(name, nil); err != ErrInvalidFormat {
+		t.Fatal("Garbage should be rejected")
+	}
+}
+
+func TestFileBitmapPageSize(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "bitmap")
+
+	b, err := OpenFile(name, &Option{AutoExpand: true, PageSize: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b.Set(1000)
+	b.Close()
+
+	fi, _ := os.Stat(name)
+	if fi.Size() != int64(fileHeaderSize+slotHeaderSize+64) {
+		t.Fatal("Slots should follow the page size")
+	}
+
+	// The page size of the file wins over the option
+	b, err = OpenFile(name, &Option{AutoExpand: true, PageSize: 128})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if b.pageSize != 64 || !b.Test(1000) || b.pages[0].id != 1000/512 {
+		t.Fatal("Page size should be read from the file")
+	}
+}
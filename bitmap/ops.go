@@ -0,0 +1,302 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bitmap
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+type operation int
+
+const (
+	opAnd operation = iota
+	opOr
+	opXor
+	opAndNot
+)
+
+func (op operation) word(x, y uint64) uint64 {
+	switch op {
+	case opAnd:
+		return x & y
+	case opOr:
+		return x | y
+	case opXor:
+		return x ^ y
+	default:
+		return x &^ y
+	}
+}
+
+// apply combines src into dst, 64 bits at a time
+func (op operation) apply(dst, src []uint8) {
+	i := 0
+
+	for ; i+8 <= len(dst); i += 8 {
+		x := binary.LittleEndian.Uint64(dst[i:])
+		y := binary.LittleEndian.Uint64(src[i:])
+		binary.LittleEndian.PutUint64(dst[i:], op.word(x, y))
+	}
+
+	for ; i < len(dst); i++ {
+		dst[i] = uint8(op.word(uint64(dst[i]), uint64(src[i])))
+	}
+}
+
+// popcount returns how many bits are set in bs
+func popcount(bs []uint8) int {
+	n, i := 0, 0
+
+	for ; i+8 <= len(bs); i += 8 {
+		n += bits.OnesCount64(binary.LittleEndian.Uint64(bs[i:]))
+	}
+
+	for ; i < len(bs); i++ {
+		n += bits.OnesCount8(bs[i])
+	}
+
+	return n
+}
+
+func (p *page) clone() *page {
+	bs := make([]uint8, len(p.bits))
+	copy(bs, p.bits)
+
+	return &page{
+		id:   p.id,
+		size: p.size,
+		bits: bs,
+	}
+}
+
+func allZero(bs []uint8) bool {
+	for _, x := range bs {
+		if x != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// repage copies sorted pages laid out by from into new pages laid out by l,
+// skipping the empty ones
+func (l layout) repage(pages []*page, from layout) []*page {
+	var out []*page
+
+	for _, p := range pages {
+		if p.size == 0 {
+			continue
+		}
+
+		start := from.position(p.id, 0) / bitsPerByte // in bytes
+
+		for j := 0; j < len(p.bits); {
+			id := (start + uint64(j)) / uint64(l.pageSize)
+			off := int((start + uint64(j)) % uint64(l.pageSize))
+
+			n := len(p.bits) - j
+			if n > l.pageSize-off {
+				n = l.pageSize - off
+			}
+
+			if !allZero(p.bits[j : j+n]) {
+				if len(out) == 0 || out[len(out)-1].id != id {
+					out = append(out, &page{
+						id:   id,
+						bits: make([]uint8, l.pageSize),
+					})
+				}
+
+				copy(out[len(out)-1].bits[off:], p.bits[j:j+n])
+			}
+
+			j += n
+		}
+	}
+
+	for _, p := range out {
+		p.size = popcount(p.bits)
+	}
+
+	return out
+}
+
+// Clone returns a deep copy of the Bitmap, with the same option
+func (b *Bitmap) Clone() *Bitmap {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := New(b.option)
+	c.pages = make([]*page, len(b.pages))
+	c.size = b.size
+
+	for i, p := range b.pages {
+		c.pages[i] = p.clone()
+	}
+
+	return c
+}
+
+// operand returns a copy of the pages of other, laid out as the pages of b.
+// Working on a copy, other is never locked while b is, so two bitmaps
+// combined with each other in both orders can't deadlock.
+func (b *Bitmap) operand(other *Bitmap) []*page {
+	c := other.Clone()
+
+	if c.layout != b.layout {
+		return b.repage(c.pages, c.layout)
+	}
+
+	return c.pages
+}
+
+// combine merges the sorted pages of the other operand into b.pages.
+// Pages missing from one side are skipped, or adopted as is when the
+// operation keeps bits found only in the other operand.
+func (b *Bitmap) combine(op operation, others []*page) {
+	pages := make([]*page, 0, len(b.pages))
+
+	i, j := 0, 0
+	for i < len(b.pages) || j < len(others) {
+		switch {
+		case j == len(others) || (i < len(b.pages) && b.pages[i].id < others[j].id):
+			p := b.pages[i]
+			if op == opAnd {
+				for k := range p.bits {
+					p.bits[k] = 0
+				}
+			}
+			pages = append(pages, p)
+			i++
+
+		case i == len(b.pages) || others[j].id < b.pages[i].id:
+			if op == opOr || op == opXor {
+				pages = append(pages, others[j])
+			}
+			j++
+
+		default:
+			op.apply(b.pages[i].bits, others[j].bits)
+			pages = append(pages, b.pages[i])
+			i++
+			j++
+		}
+	}
+
+	b.pages = pages[:0]
+	b.size = 0
+
+	for _, p := range pages {
+		if !b.option.AutoExpand {
+			// Never allocated by b itself, only adopted from others
+			if b.position(p.id, 0) >= b.option.Capacity {
+				continue
+			}
+			b.truncatePage(p)
+		}
+
+		p.size = popcount(p.bits)
+		if p.size == 0 && b.option.AutoRecycle {
+			continue
+		}
+
+		b.pages = append(b.pages, p)
+		b.size += uint64(p.size)
+	}
+
+	for k := len(b.pages); k < len(pages); k++ {
+		pages[k] = nil
+	}
+}
+
+// truncatePage clears the bits of p lying beyond the capacity of b
+func (b *Bitmap) truncatePage(p *page) {
+	limit := b.option.Capacity - b.position(p.id, 0) // bits allowed in this page
+	if limit >= uint64(b.bitsPerPage) {
+		return
+	}
+
+	for k := int(limit); k < b.bitsPerPage; k++ {
+		p.bits[k/bitsPerByte] &^= 1 << uint8(k%bitsPerByte)
+	}
+}
+
+// And keeps only the bits set in both b and other
+func (b *Bitmap) And(other *Bitmap) {
+	others := b.operand(other)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.combine(opAnd, others)
+}
+
+// Or sets the bits set in other as well
+func (b *Bitmap) Or(other *Bitmap) {
+	others := b.operand(other)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.combine(opOr, others)
+}
+
+// Xor keeps the bits set in exactly one of b and other
+func (b *Bitmap) Xor(other *Bitmap) {
+	others := b.operand(other)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.combine(opXor, others)
+}
+
+// AndNot clears the bits set in other
+func (b *Bitmap) AndNot(other *Bitmap) {
+	others := b.operand(other)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.combine(opAndNot, others)
+}
+
+// And returns a new Bitmap holding the intersection of x and y.
+// The result is built with the option of x.
+func And(x, y *Bitmap) *Bitmap {
+	b := x.Clone()
+	b.And(y)
+
+	return b
+}
+
+// Or returns a new Bitmap holding the union of x and y.
+// The result is built with the option of x.
+func Or(x, y *Bitmap) *Bitmap {
+	b := x.Clone()
+	b.Or(y)
+
+	return b
+}
+
+// Xor returns a new Bitmap holding the symmetric difference of x and y.
+// The result is built with the option of x.
+func Xor(x, y *Bitmap) *Bitmap {
+	b := x.Clone()
+	b.Xor(y)
+
+	return b
+}
+
+// AndNot returns a new Bitmap holding the bits of x not set in y.
+// The result is built with the option of x.
+func AndNot(x, y *Bitmap) *Bitmap {
+	b := x.Clone()
+	b.AndNot(y)
+
+	return b
+}
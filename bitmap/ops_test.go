@@ -0,0 +1,112 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bitmap
+
+import (
+	"testing"
+)
+
+func newBitmapOf(option *Option, ns ...uint64) *Bitmap {
+	b := New(option)
+	for _, n := range ns {
+		b.Set(n)
+	}
+
+	return b
+}
+
+func checkBitmap(t *testing.T, name string, b *Bitmap, ns ...uint64) {
+	if b.Size() != uint64(len(ns)) {
+		t.Fatalf("%s: size %d should equal to %d", name, b.Size(), len(ns))
+	}
+
+	for _, n := range ns {
+		if !b.Test(n) {
+			t.Fatalf("%s: %d should be set", name, n)
+		}
+	}
+}
+
+func TestBitmapOperations(t *testing.T) {
+	far := position(3, 5)
+	second := position(1, 0)
+	third := position(2, 0)
+
+	x := newBitmapOf(nil, 0, 2, second, far)
+	y := newBitmapOf(nil, 2, 3, third, far)
+
+	checkBitmap(t, "and", And(x, y), 2, far)
+	checkBitmap(t, "or", Or(x, y), 0, 2, 3, second, third, far)
+	checkBitmap(t, "xor", Xor(x, y), 0, 3, second, third)
+	checkBitmap(t, "andnot", AndNot(x, y), 0, second)
+
+	// Operands are left untouched
+	checkBitmap(t, "x", x, 0, 2, second, far)
+	checkBitmap(t, "y", y, 2, 3, third, far)
+
+	if b := And(x, y); len(b.pages) != 2 {
+		t.Fatal("Emptied pages should be recycled")
+	}
+
+	x.And(y)
+	checkBitmap(t, "in-place and", x, 2, far)
+
+	x.Or(y)
+	checkBitmap(t, "in-place or", x, 2, 3, third, far)
+
+	x.Xor(y)
+	checkBitmap(t, "in-place xor", x)
+
+	if len(x.pages) != 0 {
+		t.Fatal("Emptied pages should be recycled")
+	}
+
+	y.AndNot(y)
+	checkBitmap(t, "self andnot", y)
+}
+
+func TestBitmapOperationsOption(t *testing.T) {
+	x := newBitmapOf(&Option{
+		Capacity:    10,
+		AutoExpand:  false,
+		AutoRecycle: false,
+	}, 4)
+
+	second := position(1, 0)
+
+	y := newBitmapOf(nil, 4, 9, 10, second)
+
+	x.Or(y)
+	checkBitmap(t, "limited or", x, 4, 9)
+
+	if x.Test(10) || x.Test(second) {
+		t.Fatal("Bits beyond the capacity should be dropped")
+	}
+
+	x.AndNot(y)
+	checkBitmap(t, "limited andnot", x)
+
+	if len(x.pages) != 1 {
+		t.Fatal("Emptied pages should be kept with AutoRecycle disabled")
+	}
+
+	checkBitmap(t, "unlimited xor", Xor(y, x), 4, 9, 10, second)
+}
+
+func TestBitmapOperationsPageSize(t *testing.T) {
+	small := &Option{AutoExpand: true, AutoRecycle: true, PageSize: 8}
+
+	x := newBitmapOf(small, 0, 70, 5000, position(3, 1))
+	y := newBitmapOf(nil, 70, 71, position(3, 1))
+
+	checkBitmap(t, "and", And(x, y), 70, position(3, 1))
+	checkBitmap(t, "or", Or(y, x), 0, 70, 71, 5000, position(3, 1))
+	checkBitmap(t, "xor", Xor(x, y), 0, 71, 5000)
+	checkBitmap(t, "andnot", AndNot(y, x), 71)
+
+	if b := Or(x, y); b.pageSize != 8 {
+		t.Fatal("Result should keep the option of its first operand")
+	}
+}
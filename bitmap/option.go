@@ -12,8 +12,13 @@ type Option struct {
 	// Automatically to recycle resources after delete elements from the bitmap
 	AutoRecycle bool
 
-	// Initial capacity of this Bitmap
-	Capacity int
+	// Initial capacity of this Bitmap, bits from 0 to Capacity-1 can be set
+	// when AutoExpand is disabled
+	Capacity uint64
+
+	// Size in bytes of one page, rounded up to a multiple of 8 bytes.
+	// Zero means the page size of the OS.
+	PageSize int
 }
 
 func (o *Option) clone() *Option {
@@ -21,5 +26,6 @@ func (o *Option) clone() *Option {
 		AutoExpand:  o.AutoExpand,
 		AutoRecycle: o.AutoRecycle,
 		Capacity:    o.Capacity,
+		PageSize:    o.PageSize,
 	}
 }
@@ -0,0 +1,219 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bitmap
+
+// Ranges include both of their ends, so the last bit of the uint64 range
+// can be reached
+
+type rangeOp int
+
+const (
+	rangeSet rangeOp = iota
+	rangeClear
+	rangeFlip
+)
+
+// pageRange returns the offsets of the range from-to inside the page id
+func (l layout) pageRange(id, from, to uint64) (int, int) {
+	lo, hi := 0, l.bitsPerPage-1
+
+	if l.pageOf(from) == id {
+		lo = l.offsetOf(from)
+	}
+
+	if l.pageOf(to) == id {
+		hi = l.offsetOf(to)
+	}
+
+	return lo, hi
+}
+
+// countRange returns how many bits are set from offset lo to hi included
+func (p *page) countRange(lo, hi int) int {
+	if lo == 0 && hi == p.bitsLen()-1 {
+		return p.size
+	}
+
+	n := p.countTo(hi)
+	if lo > 0 {
+		n -= p.countTo(lo - 1)
+	}
+
+	return n
+}
+
+// fillRange applies op to the bits from offset lo to hi included, the
+// partial bytes at both ends bit by bit and the whole bytes in between at once
+func (p *page) fillRange(lo, hi int, op rangeOp) {
+	for ; lo <= hi && lo%bitsPerByte != 0; lo++ {
+		p.fillByte(lo/bitsPerByte, uint8(1)<<uint8(lo%bitsPerByte), op)
+	}
+
+	for ; hi >= lo && hi%bitsPerByte != bitsPerByte-1; hi-- {
+		p.fillByte(hi/bitsPerByte, uint8(1)<<uint8(hi%bitsPerByte), op)
+	}
+
+	if lo > hi {
+		return
+	}
+
+	bytes := p.bits[lo/bitsPerByte : hi/bitsPerByte+1]
+
+	switch op {
+	case rangeSet:
+		for i := range bytes {
+			bytes[i] = 0xff
+		}
+	case rangeClear:
+		for i := range bytes {
+			bytes[i] = 0
+		}
+	default:
+		for i := range bytes {
+			bytes[i] ^= 0xff
+		}
+	}
+}
+
+// fillByte applies op to the bits of mask in the byte idx
+func (p *page) fillByte(idx int, mask uint8, op rangeOp) {
+	switch op {
+	case rangeSet:
+		p.bits[idx] |= mask
+	case rangeClear:
+		p.bits[idx] &^= mask
+	default:
+		p.bits[idx] ^= mask
+	}
+}
+
+func (b *Bitmap) checkRange(from, to uint64) error {
+	if from > to {
+		return ErrInvalidRange
+	}
+
+	if !b.inRange(to) {
+		return ErrOutOfRange
+	}
+
+	return nil
+}
+
+// applyRange runs op over one page, returns how many bits changed
+// and whether the page got recycled
+func (b *Bitmap) applyRange(i int, p *page, from, to uint64, op rangeOp) (uint64, bool) {
+	lo, hi := b.pageRange(p.id, from, to)
+
+	before := p.countRange(lo, hi)
+	after, changed := 0, 0
+
+	switch op {
+	case rangeSet:
+		after = hi - lo + 1
+		changed = after - before
+	case rangeClear:
+		changed = before
+	default:
+		after = hi - lo + 1 - before
+		changed = hi - lo + 1
+	}
+
+	p.fillRange(lo, hi, op)
+
+	p.size += after - before
+	b.size += uint64(after)
+	b.size -= uint64(before)
+
+	if p.size == 0 && b.option.AutoRecycle {
+		b.removePage(i)
+		return uint64(changed), true
+	}
+
+	return uint64(changed), false
+}
+
+// fillPages runs op over every page of the range, allocating the missing
+// ones. Only useful for the operations setting bits.
+func (b *Bitmap) fillPages(from, to uint64, op rangeOp) (uint64, error) {
+	if err := b.checkRange(from, to); err != nil {
+		return 0, err
+	}
+
+	changed := uint64(0)
+
+	for id := b.pageOf(from); ; id++ {
+		i, p := b.getPage(b.position(id, 0), true)
+
+		n, _ := b.applyRange(i, p, from, to, op)
+		changed += n
+
+		if id == b.pageOf(to) {
+			break
+		}
+	}
+
+	return changed, nil
+}
+
+// SetRange sets the bits from and to included, returns how many changed
+func (b *Bitmap) SetRange(from, to uint64) (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.fillPages(from, to, rangeSet)
+}
+
+// FlipRange flips the bits from and to included, returns how many changed
+func (b *Bitmap) FlipRange(from, to uint64) (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.fillPages(from, to, rangeFlip)
+}
+
+// ClearRange clears the bits from and to included, returns how many changed.
+// Only the pages already allocated are visited.
+func (b *Bitmap) ClearRange(from, to uint64) (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.checkRange(from, to); err != nil {
+		return 0, err
+	}
+
+	changed := uint64(0)
+
+	i, _ := b.searchPage(b.pageOf(from))
+	for i < len(b.pages) && b.pages[i].id <= b.pageOf(to) {
+		n, recycled := b.applyRange(i, b.pages[i], from, to, rangeClear)
+		changed += n
+
+		if !recycled {
+			i++
+		}
+	}
+
+	return changed, nil
+}
+
+// CountRange returns how many bits are set from and to included
+func (b *Bitmap) CountRange(from, to uint64) uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if from > to {
+		return 0
+	}
+
+	count := uint64(0)
+
+	i, _ := b.searchPage(b.pageOf(from))
+	for ; i < len(b.pages) && b.pages[i].id <= b.pageOf(to); i++ {
+		lo, hi := b.pageRange(b.pages[i].id, from, to)
+		count += uint64(b.pages[i].countRange(lo, hi))
+	}
+
+	return count
+}
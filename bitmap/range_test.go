@@ -0,0 +1,180 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bitmap
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestBitmapRange(t *testing.T) {
+	bm := New(nil)
+
+	from, to := position(1, 5), position(4, 2)
+
+	if n, err := bm.SetRange(from, to); err != nil || n != to-from+1 {
+		t.Fatal("Every bit of the range should be set")
+	}
+
+	if len(bm.pages) != 4 || bm.pages[1].size != bitsPerPage {
+		t.Fatal("Whole pages should be filled")
+	}
+
+	if bm.Test(from-1) || !bm.Test(from) || !bm.Test(to) || bm.Test(to+1) {
+		t.Fatal("Range should stop at its ends")
+	}
+
+	if n := bm.CountRange(0, math.MaxUint64); n != bm.Size() || n != to-from+1 {
+		t.Fatal("CountRange should count every bit set")
+	}
+
+	if n, _ := bm.ClearRange(position(2, 0), position(3, 0)-1); n != uint64(bitsPerPage) {
+		t.Fatal("A whole page should be cleared")
+	}
+
+	if len(bm.pages) != 3 {
+		t.Fatal("Emptied page should be recycled")
+	}
+
+	if n, _ := bm.FlipRange(from, to); n != to-from+1 || bm.Size() != uint64(bitsPerPage) {
+		t.Fatal("Flipping should only leave the cleared page set")
+	}
+
+	if n, _ := bm.ClearRange(0, math.MaxUint64); n != uint64(bitsPerPage) || len(bm.pages) != 0 {
+		t.Fatal("Clearing everything should recycle every page")
+	}
+
+	if _, err := bm.SetRange(2, 1); err != ErrInvalidRange {
+		t.Fatal("Reversed range should be rejected")
+	}
+
+	if n, _ := bm.SetRange(math.MaxUint64, math.MaxUint64); n != 1 || !bm.Test(math.MaxUint64) {
+		t.Fatal("Last bit should be reachable")
+	}
+}
+
+func TestBitmapRangeOption(t *testing.T) {
+	bm := New(&Option{
+		Capacity:    position(2, 0),
+		AutoExpand:  false,
+		AutoRecycle: false,
+	})
+
+	if _, err := bm.SetRange(0, position(2, 0)); err != ErrOutOfRange || bm.Size() != 0 {
+		t.Fatal("Range beyond the capacity should be rejected")
+	}
+
+	bm.SetRange(0, position(2, 0)-1)
+	bm.ClearRange(0, position(2, 0)-1)
+
+	if len(bm.pages) != 2 || bm.Size() != 0 {
+		t.Fatal("Emptied pages should be kept with AutoRecycle disabled")
+	}
+}
+
+func TestBitmapRangeRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	bm := New(nil)
+	model := make(map[uint64]bool)
+	limit := position(4, 0)
+
+	for i := 0; i < 300; i++ {
+		from := uint64(r.Int63n(int64(limit)))
+		to := from + uint64(r.Intn(2*bitsPerPage))
+		if to >= limit {
+			to = limit - 1
+		}
+
+		expected := uint64(0)
+		for n := from; n <= to; n++ {
+			if model[n] {
+				expected++
+			}
+		}
+
+		if bm.CountRange(from, to) != expected {
+			t.Fatalf("CountRange(%d, %d) should be %d", from, to, expected)
+		}
+
+		switch r.Intn(3) {
+		case 0:
+			bm.SetRange(from, to)
+			for n := from; n <= to; n++ {
+				model[n] = true
+			}
+		case 1:
+			bm.ClearRange(from, to)
+			for n := from; n <= to; n++ {
+				delete(model, n)
+			}
+		default:
+			bm.FlipRange(from, to)
+			for n := from; n <= to; n++ {
+				if model[n] {
+					delete(model, n)
+				} else {
+					model[n] = true
+				}
+			}
+		}
+	}
+
+	checkCounts(t, bm)
+
+	if bm.Size() != uint64(len(model)) {
+		t.Fatalf("Size %d should equal to %d", bm.Size(), len(model))
+	}
+
+	for n := uint64(0); n < limit; n++ {
+		if bm.Test(n) != model[n] {
+			t.Fatalf("%d mismatched", n)
+		}
+	}
+}
+
+func TestPageFillRange(t *testing.T) {
+	p := &page{bits: make([]uint8, 3)}
+
+	for lo := 0; lo < p.bitsLen(); lo++ {
+		for hi := lo; hi < p.bitsLen(); hi++ {
+			for i := range p.bits {
+				p.bits[i] = 0x5a
+			}
+
+			p.fillRange(lo, hi, rangeFlip)
+
+			for o := 0; o < p.bitsLen(); o++ {
+				was := uint8(0x5a)>>uint8(o%bitsPerByte)&1 == 1
+				is := p.bits[o/bitsPerByte]>>uint8(o%bitsPerByte)&1 == 1
+
+				if (o >= lo && o <= hi) == (was == is) {
+					t.Fatalf("fillRange(%d, %d) mismatched at %d", lo, hi, o)
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkSetRange(b *testing.B) {
+	bm := New(nil)
+
+	for i := 0; i < b.N; i++ {
+		bm.SetRange(0, 1<<20)
+		bm.ClearAll()
+	}
+}
+
+func BenchmarkSetRangeBitByBit(b *testing.B) {
+	bm := New(nil)
+
+	for i := 0; i < b.N; i++ {
+		for n := uint64(0); n <= 1<<20; n++ {
+			bm.Set(n)
+		}
+		bm.ClearAll()
+	}
+}
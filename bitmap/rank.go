@@ -0,0 +1,226 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bitmap
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+)
+
+// Bits of a page are scanned one 64 bits word at a time, offsets below are
+// 0-based bit offsets inside a page
+
+const bitsPerWord = 64
+
+func (p *page) word(o int) uint64 {
+	return binary.LittleEndian.Uint64(p.bits[o/bitsPerWord*8:])
+}
+
+// nextSet returns the offset of the first bit set at o or after, or -1
+func (p *page) nextSet(o int) int {
+	for o < p.bitsLen() {
+		if w := p.word(o) >> uint(o%bitsPerWord); w != 0 {
+			return o + bits.TrailingZeros64(w)
+		}
+
+		o = (o/bitsPerWord + 1) * bitsPerWord
+	}
+
+	return -1
+}
+
+// nextClear returns the offset of the first bit unset at o or after, or -1
+func (p *page) nextClear(o int) int {
+	if p.size == p.bitsLen() {
+		return -1
+	}
+
+	for o < p.bitsLen() {
+		if w := ^p.word(o) >> uint(o%bitsPerWord); w != 0 {
+			return o + bits.TrailingZeros64(w)
+		}
+
+		o = (o/bitsPerWord + 1) * bitsPerWord
+	}
+
+	return -1
+}
+
+// prevSet returns the offset of the last bit set at o or before, or -1
+func (p *page) prevSet(o int) int {
+	for o >= 0 {
+		if w := p.word(o) << uint(bitsPerWord-1-o%bitsPerWord); w != 0 {
+			return o - bits.LeadingZeros64(w)
+		}
+
+		o = o/bitsPerWord*bitsPerWord - 1
+	}
+
+	return -1
+}
+
+// countTo returns how many bits are set from offset 0 to o included
+func (p *page) countTo(o int) int {
+	end := o / bitsPerWord * bitsPerWord
+
+	n := popcount(p.bits[:end/bitsPerByte])
+	n += bits.OnesCount64(p.word(o) << uint(bitsPerWord-1-o%bitsPerWord))
+
+	return n
+}
+
+// selectBit returns the offset of the k-th set bit of the page, k >= 1
+func (p *page) selectBit(k int) int {
+	for o := 0; o < p.bitsLen(); o += bitsPerWord {
+		w := p.word(o)
+
+		c := bits.OnesCount64(w)
+		if k > c {
+			k -= c
+			continue
+		}
+
+		for ; k > 1; k-- {
+			w &= w - 1
+		}
+
+		return o + bits.TrailingZeros64(w)
+	}
+
+	return -1
+}
+
+// NextSet returns the first bit set at n or after
+func (b *Bitmap) NextSet(n uint64) (uint64, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.nextSet(n)
+}
+
+func (b *Bitmap) nextSet(n uint64) (uint64, bool) {
+	i, _ := b.searchPage(b.pageOf(n))
+
+	for ; i < len(b.pages); i++ {
+		p := b.pages[i]
+
+		o := 0
+		if p.id == b.pageOf(n) {
+			o = b.offsetOf(n)
+		}
+
+		if o = p.nextSet(o); o >= 0 {
+			return b.position(p.id, o), true
+		}
+	}
+
+	return 0, false
+}
+
+// PrevSet returns the last bit set at n or before
+func (b *Bitmap) PrevSet(n uint64) (uint64, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	i, ok := b.searchPage(b.pageOf(n))
+	if !ok {
+		i--
+	}
+
+	for ; i >= 0; i-- {
+		p := b.pages[i]
+
+		o := b.bitsPerPage - 1
+		if p.id == b.pageOf(n) {
+			o = b.offsetOf(n)
+		}
+
+		if o = p.prevSet(o); o >= 0 {
+			return b.position(p.id, o), true
+		}
+	}
+
+	return 0, false
+}
+
+// Rank returns how many bits are set from 0 to n included
+func (b *Bitmap) Rank(n uint64) uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	rank := uint64(0)
+
+	// Whole pages before n are counted by their cached size
+	for _, p := range b.pages {
+		if p.id > b.pageOf(n) {
+			break
+		}
+
+		if p.id < b.pageOf(n) {
+			rank += uint64(p.size)
+		} else {
+			rank += uint64(p.countTo(b.offsetOf(n)))
+		}
+	}
+
+	return rank
+}
+
+// Select returns the bit set having k bits set before it, counting from 0,
+// so that Rank of the result is k+1
+func (b *Bitmap) Select(k uint64) (uint64, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, p := range b.pages {
+		if k >= uint64(p.size) {
+			k -= uint64(p.size)
+			continue
+		}
+
+		if o := p.selectBit(int(k) + 1); o >= 0 {
+			return b.position(p.id, o), true
+		}
+
+		break
+	}
+
+	return 0, false
+}
+
+// Iterator walks through the bits set in a Bitmap in increasing order.
+// It doesn't hold the lock between two steps, so bits set or cleared
+// behind it are seen or not depending on where it stands.
+type Iterator struct {
+	b    *Bitmap
+	next uint64
+	done bool
+}
+
+// Return back an Iterator starting at the first bit set
+func (b *Bitmap) Iterator() *Iterator {
+	return &Iterator{
+		b:    b,
+		next: 0,
+	}
+}
+
+// Next returns the next bit set, false once all of them were returned
+func (it *Iterator) Next() (uint64, bool) {
+	if it.done {
+		return 0, false
+	}
+
+	n, ok := it.b.NextSet(it.next)
+	if !ok || n == math.MaxUint64 {
+		it.done = true
+		return n, ok
+	}
+
+	it.next = n + 1
+
+	return n, true
+}
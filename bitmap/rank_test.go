@@ -0,0 +1,95 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bitmap
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestBitmapNextPrevSet(t *testing.T) {
+	last := position(3, 0) - 1 // last bit of the third page
+
+	bm := newBitmapOf(nil, 3, 63, 64, last)
+
+	cases := []struct {
+		n          uint64
+		next, prev uint64
+	}{
+		{0, 3, 0},
+		{3, 3, 3},
+		{4, 63, 3},
+		{64, 64, 64},
+		{65, last, 64},
+		{position(1, 0), last, 64},
+		{last, last, last},
+		{last + 1, 0, last},
+	}
+
+	for _, c := range cases {
+		if n, _ := bm.NextSet(c.n); n != c.next {
+			t.Fatalf("NextSet(%d) should be %d, got %d", c.n, c.next, n)
+		}
+
+		if n, _ := bm.PrevSet(c.n); n != c.prev {
+			t.Fatalf("PrevSet(%d) should be %d, got %d", c.n, c.prev, n)
+		}
+	}
+
+	if _, ok := bm.PrevSet(2); ok {
+		t.Fatal("No bit should be set before 3")
+	}
+}
+
+func TestBitmapRankSelect(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	bm := New(nil)
+	model := make(map[uint64]bool)
+
+	for len(model) < 5000 {
+		n := uint64(r.Int63n(int64(position(10, 0))))
+		if !model[n] {
+			bm.Set(n)
+			model[n] = true
+		}
+	}
+
+	expected := make([]uint64, 0, len(model))
+	for n := range model {
+		expected = append(expected, n)
+	}
+	sort.Slice(expected, func(i, j int) bool {
+		return expected[i] < expected[j]
+	})
+
+	it := bm.Iterator()
+	for i, e := range expected {
+		if n, ok := it.Next(); !ok || n != e {
+			t.Fatalf("Iterator should return %d at step %d", e, i)
+		}
+
+		if n, ok := bm.Select(uint64(i)); !ok || n != e {
+			t.Fatalf("Select(%d) should be %d", i, e)
+		}
+
+		if bm.Rank(e) != uint64(i+1) || (e > 0 && bm.Rank(e-1) != uint64(i)) {
+			t.Fatalf("Rank(%d) should be %d", e, i+1)
+		}
+	}
+
+	if _, ok := it.Next(); ok {
+		t.Fatal("Iterator should be exhausted")
+	}
+
+	if _, ok := bm.Select(uint64(len(expected))); ok {
+		t.Fatal("Select beyond the size should fail")
+	}
+
+	if bm.Rank(position(20, 0)) != uint64(len(expected)) {
+		t.Fatal("Rank beyond the last bit should be the size")
+	}
+}
@@ -0,0 +1,363 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package roaring
+
+import (
+	"math/bits"
+	"sort"
+)
+
+const (
+	// Above this cardinality a bitmap container is smaller than an array
+	arrayMaxSize = 4096
+
+	bitmapWords = 1 << 16 / 64
+	bitmapBytes = bitmapWords * 8
+)
+
+// container stores the low 16 bits of the values sharing one chunk.
+// add and remove return the container to use afterwards, which differs
+// from the receiver whenever another kind became a better fit.
+type container interface {
+	add(x uint16) (container, bool)
+	remove(x uint16) (container, bool)
+	contains(x uint16) bool
+	cardinality() int
+	numRuns() int
+	iterate(f func(x uint16))
+}
+
+// optimize picks the smallest container kind for the values held by c
+func optimize(c container) container {
+	runBytes := 2 + 4*c.numRuns()
+
+	otherBytes := bitmapBytes
+	if c.cardinality() <= arrayMaxSize {
+		otherBytes = 2 * c.cardinality()
+	}
+
+	if runBytes < otherBytes {
+		if _, ok := c.(*runContainer); ok {
+			return c
+		}
+		return toRunContainer(c)
+	}
+
+	return toNonRunContainer(c)
+}
+
+// toNonRunContainer converts c to an array or a bitmap by its cardinality
+func toNonRunContainer(c container) container {
+	if c.cardinality() <= arrayMaxSize {
+		if _, ok := c.(*arrayContainer); ok {
+			return c
+		}
+
+		a := &arrayContainer{content: make([]uint16, 0, c.cardinality())}
+		c.iterate(func(x uint16) {
+			a.content = append(a.content, x)
+		})
+		return a
+	}
+
+	if _, ok := c.(*bitmapContainer); ok {
+		return c
+	}
+
+	bc := newBitmapContainer()
+	c.iterate(func(x uint16) {
+		bc.words[x/64] |= 1 << (x % 64)
+	})
+	bc.card = c.cardinality()
+	return bc
+}
+
+func toRunContainer(c container) *runContainer {
+	r := &runContainer{
+		runs: make([]interval, 0, c.numRuns()),
+		card: c.cardinality(),
+	}
+
+	c.iterate(func(x uint16) {
+		if n := len(r.runs); n > 0 && int(r.runs[n-1].last)+1 == int(x) {
+			r.runs[n-1].last = x
+		} else {
+			r.runs = append(r.runs, interval{x, x})
+		}
+	})
+
+	return r
+}
+
+// arrayContainer keeps the values in a sorted slice
+type arrayContainer struct {
+	content []uint16
+}
+
+func newArrayContainer() *arrayContainer {
+	return &arrayContainer{}
+}
+
+func (a *arrayContainer) search(x uint16) (int, bool) {
+	i := sort.Search(len(a.content), func(i int) bool {
+		return a.content[i] >= x
+	})
+
+	return i, i < len(a.content) && a.content[i] == x
+}
+
+func (a *arrayContainer) add(x uint16) (container, bool) {
+	i, ok := a.search(x)
+	if ok {
+		return a, false
+	}
+
+	if len(a.content) >= arrayMaxSize {
+		return toBitmapContainer(a).add(x)
+	}
+
+	a.content = append(a.content, 0)
+	copy(a.content[i+1:], a.content[i:])
+	a.content[i] = x
+
+	return a, true
+}
+
+func (a *arrayContainer) remove(x uint16) (container, bool) {
+	i, ok := a.search(x)
+	if !ok {
+		return a, false
+	}
+
+	a.content = append(a.content[:i], a.content[i+1:]...)
+
+	return a, true
+}
+
+func (a *arrayContainer) contains(x uint16) bool {
+	_, ok := a.search(x)
+	return ok
+}
+
+func (a *arrayContainer) cardinality() int {
+	return len(a.content)
+}
+
+func (a *arrayContainer) numRuns() int {
+	runs := 0
+
+	for i, x := range a.content {
+		if i == 0 || a.content[i-1]+1 != x {
+			runs++
+		}
+	}
+
+	return runs
+}
+
+func (a *arrayContainer) iterate(f func(x uint16)) {
+	for _, x := range a.content {
+		f(x)
+	}
+}
+
+// bitmapContainer keeps one bit for every value of the chunk
+type bitmapContainer struct {
+	card  int
+	words []uint64
+}
+
+func newBitmapContainer() *bitmapContainer {
+	return &bitmapContainer{
+		words: make([]uint64, bitmapWords),
+	}
+}
+
+func toBitmapContainer(c container) *bitmapContainer {
+	bc := newBitmapContainer()
+
+	c.iterate(func(x uint16) {
+		bc.words[x/64] |= 1 << (x % 64)
+	})
+	bc.card = c.cardinality()
+
+	return bc
+}
+
+func (bc *bitmapContainer) add(x uint16) (container, bool) {
+	mask := uint64(1) << (x % 64)
+	if bc.words[x/64]&mask != 0 {
+		return bc, false
+	}
+
+	bc.words[x/64] |= mask
+	bc.card++
+
+	// A full chunk is a single run
+	if bc.card == 1<<16 {
+		return &runContainer{runs: []interval{{0, 0xffff}}, card: bc.card}, true
+	}
+
+	return bc, true
+}
+
+func (bc *bitmapContainer) remove(x uint16) (container, bool) {
+	mask := uint64(1) << (x % 64)
+	if bc.words[x/64]&mask == 0 {
+		return bc, false
+	}
+
+	bc.words[x/64] &^= mask
+	bc.card--
+
+	if bc.card <= arrayMaxSize {
+		return toNonRunContainer(bc), true
+	}
+
+	return bc, true
+}
+
+func (bc *bitmapContainer) contains(x uint16) bool {
+	return bc.words[x/64]&(1<<(x%64)) != 0
+}
+
+func (bc *bitmapContainer) cardinality() int {
+	return bc.card
+}
+
+func (bc *bitmapContainer) numRuns() int {
+	runs := 0
+	carry := uint64(0) // highest bit of the previous word
+
+	for _, w := range bc.words {
+		// A run starts wherever a set bit follows an unset one
+		runs += bits.OnesCount64(w &^ (w<<1 | carry))
+		carry = w >> 63
+	}
+
+	return runs
+}
+
+func (bc *bitmapContainer) iterate(f func(x uint16)) {
+	for i, w := range bc.words {
+		for w != 0 {
+			f(uint16(i*64 + bits.TrailingZeros64(w)))
+			w &= w - 1
+		}
+	}
+}
+
+// interval is a run of values, both ends included
+type interval struct {
+	start uint16
+	last  uint16
+}
+
+// runContainer keeps sorted, non adjacent runs of values
+type runContainer struct {
+	card int
+	runs []interval
+}
+
+// search returns the index of the run containing x, or -1 if x isn't held.
+// The second result is the index of the first run starting after x.
+func (r *runContainer) search(x uint16) (int, int) {
+	i := sort.Search(len(r.runs), func(i int) bool {
+		return r.runs[i].start > x
+	})
+
+	if i > 0 && x <= r.runs[i-1].last {
+		return i - 1, i
+	}
+
+	return -1, i
+}
+
+func (r *runContainer) add(x uint16) (container, bool) {
+	at, next := r.search(x)
+	if at >= 0 {
+		return r, false
+	}
+
+	extendPrev := next > 0 && int(r.runs[next-1].last)+1 == int(x)
+	extendNext := next < len(r.runs) && int(x)+1 == int(r.runs[next].start)
+
+	switch {
+	case extendPrev && extendNext:
+		r.runs[next-1].last = r.runs[next].last
+		r.runs = append(r.runs[:next], r.runs[next+1:]...)
+	case extendPrev:
+		r.runs[next-1].last = x
+	case extendNext:
+		r.runs[next].start = x
+	default:
+		r.runs = append(r.runs, interval{})
+		copy(r.runs[next+1:], r.runs[next:])
+		r.runs[next] = interval{x, x}
+	}
+
+	r.card++
+
+	return r.shrink(), true
+}
+
+func (r *runContainer) remove(x uint16) (container, bool) {
+	at, _ := r.search(x)
+	if at < 0 {
+		return r, false
+	}
+
+	run := r.runs[at]
+
+	switch {
+	case run.start == x && run.last == x:
+		r.runs = append(r.runs[:at], r.runs[at+1:]...)
+	case run.start == x:
+		r.runs[at].start++
+	case run.last == x:
+		r.runs[at].last--
+	default:
+		r.runs = append(r.runs, interval{})
+		copy(r.runs[at+1:], r.runs[at:])
+		r.runs[at].last = x - 1
+		r.runs[at+1].start = x + 1
+	}
+
+	r.card--
+
+	return r.shrink(), true
+}
+
+// shrink gives up the run encoding once it became the most expensive one
+func (r *runContainer) shrink() container {
+	runBytes := 2 + 4*len(r.runs)
+
+	if (r.card <= arrayMaxSize && runBytes > 2*r.card) || runBytes > bitmapBytes {
+		return toNonRunContainer(r)
+	}
+
+	return r
+}
+
+func (r *runContainer) contains(x uint16) bool {
+	at, _ := r.search(x)
+	return at >= 0
+}
+
+func (r *runContainer) cardinality() int {
+	return r.card
+}
+
+func (r *runContainer) numRuns() int {
+	return len(r.runs)
+}
+
+func (r *runContainer) iterate(f func(x uint16)) {
+	for _, run := range r.runs {
+		for x := int(run.start); x <= int(run.last); x++ {
+			f(uint16(x))
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package roaring provides a compressed bitmap following the Roaring design.
+//
+// The 32-bit space is split into chunks of 65536 values keyed by the high 16
+// bits. Each chunk is stored in the cheapest of three containers: a sorted
+// array for sparse chunks, a plain bitmap for dense ones and a list of runs
+// for chunks made of contiguous blocks. Empty chunks cost nothing.
+package roaring
+
+import (
+	"sort"
+	"sync"
+)
+
+type Bitmap struct {
+	mu sync.Mutex
+
+	size       int         // total elements stored
+	keys       []uint16    // high 16 bits of each chunk, sorted
+	containers []container // containers[i] holds the chunk keys[i]
+}
+
+// Return back a new empty Bitmap
+func New() *Bitmap {
+	return &Bitmap{}
+}
+
+func highbits(x uint32) uint16 {
+	return uint16(x >> 16)
+}
+
+func lowbits(x uint32) uint16 {
+	return uint16(x & 0xffff)
+}
+
+// search returns the index of key, or the position to insert it
+func (b *Bitmap) search(key uint16) (int, bool) {
+	i := sort.Search(len(b.keys), func(i int) bool {
+		return b.keys[i] >= key
+	})
+
+	return i, i < len(b.keys) && b.keys[i] == key
+}
+
+// Test whether one bit is set or not
+func (b *Bitmap) Test(x uint32) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	i, ok := b.search(highbits(x))
+	if !ok {
+		return false
+	}
+
+	return b.containers[i].contains(lowbits(x))
+}
+
+// Set one bit
+func (b *Bitmap) Set(x uint32) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	i, ok := b.search(highbits(x))
+	if !ok {
+		b.keys = append(b.keys, 0)
+		copy(b.keys[i+1:], b.keys[i:])
+		b.keys[i] = highbits(x)
+
+		b.containers = append(b.containers, nil)
+		copy(b.containers[i+1:], b.containers[i:])
+		b.containers[i] = newArrayContainer()
+	}
+
+	c, changed := b.containers[i].add(lowbits(x))
+	b.containers[i] = c
+
+	if changed {
+		b.size++
+	}
+}
+
+// Clear one bit
+func (b *Bitmap) Clear(x uint32) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	i, ok := b.search(highbits(x))
+	if !ok {
+		return
+	}
+
+	c, changed := b.containers[i].remove(lowbits(x))
+	b.containers[i] = c
+
+	if !changed {
+		return
+	}
+
+	b.size--
+
+	// Empty chunks are always recycled
+	if c.cardinality() == 0 {
+		b.keys = append(b.keys[:i], b.keys[i+1:]...)
+		b.containers = append(b.containers[:i], b.containers[i+1:]...)
+	}
+}
+
+// Reinit the whole Bitmap
+func (b *Bitmap) ClearAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.keys = nil
+	b.containers = nil
+	b.size = 0
+}
+
+// Total count of bits setted
+func (b *Bitmap) Size() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.size
+}
+
+// RunOptimize converts every container to a run container when it is
+// smaller that way, and back again when it's not
+func (b *Bitmap) RunOptimize() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i, c := range b.containers {
+		b.containers[i] = optimize(c)
+	}
+}
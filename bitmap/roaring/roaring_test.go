@@ -0,0 +1,248 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package roaring
+
+import (
+	"github.com/flatpeach/coconut/bitmap"
+	"math/rand"
+	"runtime"
+	"testing"
+)
+
+func TestRoaring(t *testing.T) {
+	b := New()
+
+	b.Set(10)
+	b.Set(10)
+
+	if b.Size() != 1 {
+		t.Fatal("Bitmap should has one element")
+	}
+
+	if !b.Test(10) || b.Test(11) {
+		t.Fatal("Only 10 should be set")
+	}
+
+	b.Clear(10)
+	b.Clear(10)
+
+	if b.Size() != 0 || len(b.containers) != 0 {
+		t.Fatal("Empty chunk should be recycled")
+	}
+
+	for i := uint32(0); i < 150000; i++ {
+		b.Set(i)
+
+		if !b.Test(i) {
+			t.Fatalf("%d should has one element", i)
+		}
+
+		if b.Test(i + 1) {
+			t.Fatalf("%d should not exist", i+1)
+		}
+	}
+
+	if _, ok := b.containers[0].(*runContainer); !ok {
+		t.Fatal("Full chunk should be a run container")
+	}
+
+	for i := uint32(0); i < 150000; i++ {
+		b.Clear(i)
+
+		if b.Test(i) {
+			t.Fatalf("%d should not exist", i)
+		}
+	}
+
+	if b.Size() != 0 || len(b.containers) != 0 {
+		t.Fatal("Should be empty Bitmap")
+	}
+
+	b.Set(1 << 31)
+	b.ClearAll()
+
+	if b.Size() != 0 || b.Test(1<<31) {
+		t.Fatal("ClearAll should drop everything")
+	}
+}
+
+func TestRoaringContainers(t *testing.T) {
+	b := New()
+
+	for i := uint32(0); i < arrayMaxSize; i++ {
+		b.Set(i * 2)
+	}
+
+	if _, ok := b.containers[0].(*arrayContainer); !ok {
+		t.Fatal("Sparse chunk should be an array container")
+	}
+
+	b.Set(1)
+
+	if _, ok := b.containers[0].(*bitmapContainer); !ok {
+		t.Fatal("Dense chunk should be a bitmap container")
+	}
+
+	b.Clear(1)
+
+	if _, ok := b.containers[0].(*arrayContainer); !ok {
+		t.Fatal("Chunk should turn back to an array container")
+	}
+
+	b.ClearAll()
+
+	for i := uint32(1000); i < 20000; i++ {
+		b.Set(i)
+	}
+
+	b.RunOptimize()
+
+	r, ok := b.containers[0].(*runContainer)
+	if !ok || len(r.runs) != 1 {
+		t.Fatal("Contiguous chunk should be a single run")
+	}
+
+	b.Clear(5000)
+
+	if len(r.runs) != 2 || b.Test(5000) || !b.Test(4999) || !b.Test(5001) {
+		t.Fatal("Clearing inside a run should split it")
+	}
+
+	b.Set(5000)
+
+	if len(r.runs) != 1 || b.Size() != 19000 {
+		t.Fatal("Setting the gap should merge the runs")
+	}
+}
+
+func TestRoaringRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	b := New()
+	model := make(map[uint32]bool)
+
+	for i := 0; i < 200000; i++ {
+		// Keep values in a few chunks so every container kind shows up
+		x := uint32(r.Intn(3))<<16 | uint32(r.Intn(1<<13))
+		if r.Intn(2) == 0 {
+			x = uint32(r.Intn(3))<<16 | uint32(r.Intn(1<<16))
+		}
+
+		switch r.Intn(3) {
+		case 0, 1:
+			b.Set(x)
+			model[x] = true
+		default:
+			b.Clear(x)
+			delete(model, x)
+		}
+
+		if i%50000 == 0 {
+			b.RunOptimize()
+		}
+	}
+
+	if b.Size() != len(model) {
+		t.Fatalf("Size %d should equal to %d", b.Size(), len(model))
+	}
+
+	for x := uint32(0); x < 3<<16; x++ {
+		if b.Test(x) != model[x] {
+			t.Fatalf("%d mismatched", x)
+		}
+	}
+}
+
+// heapUsage returns how many bytes stay on the heap for what build returns
+func heapUsage(build func() interface{}) uint64 {
+	var before, after runtime.MemStats
+
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	v := build()
+
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	runtime.KeepAlive(v)
+
+	if after.HeapAlloc < before.HeapAlloc {
+		return 0
+	}
+
+	return after.HeapAlloc - before.HeapAlloc
+}
+
+// values returns n values spread by step, starting at zero
+func values(n, step int) []uint32 {
+	vs := make([]uint32, n)
+	for i := range vs {
+		vs[i] = uint32(i * step)
+	}
+
+	return vs
+}
+
+func benchmarkRoaringMemory(b *testing.B, vs []uint32) {
+	build := func() interface{} {
+		rb := New()
+		for _, v := range vs {
+			rb.Set(v)
+		}
+		rb.RunOptimize()
+		return rb
+	}
+
+	for i := 0; i < b.N; i++ {
+		build()
+	}
+
+	b.ReportMetric(float64(heapUsage(build)), "heap-bytes")
+	runtime.KeepAlive(vs)
+}
+
+func benchmarkBitmapMemory(b *testing.B, vs []uint32) {
+	build := func() interface{} {
+		bm := bitmap.New(nil)
+		for _, v := range vs {
+			bm.Set(uint64(v))
+		}
+		return bm
+	}
+
+	for i := 0; i < b.N; i++ {
+		build()
+	}
+
+	b.ReportMetric(float64(heapUsage(build)), "heap-bytes")
+	runtime.KeepAlive(vs)
+}
+
+// One value every 100K, a single bit per page for bitmap.Bitmap
+func BenchmarkMemorySparseRoaring(b *testing.B) {
+	benchmarkRoaringMemory(b, values(1000, 100000))
+}
+
+func BenchmarkMemorySparseBitmap(b *testing.B) {
+	benchmarkBitmapMemory(b, values(1000, 100000))
+}
+
+// Every third value
+func BenchmarkMemoryDenseRoaring(b *testing.B) {
+	benchmarkRoaringMemory(b, values(100000, 3))
+}
+
+func BenchmarkMemoryDenseBitmap(b *testing.B) {
+	benchmarkBitmapMemory(b, values(100000, 3))
+}
+
+// A contiguous block of IDs
+func BenchmarkMemoryRunRoaring(b *testing.B) {
+	benchmarkRoaringMemory(b, values(1000000, 1))
+}
+
+func BenchmarkMemoryRunBitmap(b *testing.B) {
+	benchmarkBitmapMemory(b, values(1000000, 1))
+}
@@ -0,0 +1,421 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bitmap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math/bits"
+)
+
+// Bitmaps are serialized with the 64-bit extension of the Roaring portable
+// format, see https://github.com/RoaringBitmap/RoaringFormatSpec.
+//
+// The bits are split into buckets by their high 32 bits. The stream starts
+// with the count of buckets, then for each bucket its key followed by a
+// 32-bit Roaring bitmap holding the low 32 bits. Only chunks of 65536 bits
+// having some bits set are written, so empty regions cost nothing.
+//
+// Array and bitmap containers are written, run containers are only read.
+
+const (
+	serialCookieNoRun = 12346
+	serialCookie      = 12347
+	noOffsetThreshold = 4
+
+	chunkBytes   = 1 << 16 / bitsPerByte
+	arrayMaxSize = 4096 // above this cardinality a chunk is a bitmap container
+)
+
+// container is one chunk of 65536 bits, laid out as in a page
+type container struct {
+	key  uint16
+	card int
+	bits []uint8
+}
+
+type bucket struct {
+	key        uint32
+	containers []container
+}
+
+// buckets cuts the pages into chunks, skipping the empty ones
+func (b *Bitmap) buckets() []bucket {
+	var buckets []bucket
+
+	for _, c := range newLayout(chunkBytes).repage(b.pages, b.layout) {
+		key := uint32(c.id >> 16)
+		if len(buckets) == 0 || buckets[len(buckets)-1].key != key {
+			buckets = append(buckets, bucket{key: key})
+		}
+
+		last := &buckets[len(buckets)-1]
+		last.containers = append(last.containers, container{uint16(c.id), c.size, c.bits})
+	}
+
+	return buckets
+}
+
+func (c *container) encodedSize() int {
+	if c.card <= arrayMaxSize {
+		return 2 * c.card
+	}
+
+	return chunkBytes
+}
+
+func writeBucket(buf *bytes.Buffer, bk *bucket) {
+	var scratch [8]byte
+
+	put16 := func(x uint16) {
+		binary.LittleEndian.PutUint16(scratch[:], x)
+		buf.Write(scratch[:2])
+	}
+
+	put32 := func(x uint32) {
+		binary.LittleEndian.PutUint32(scratch[:], x)
+		buf.Write(scratch[:4])
+	}
+
+	put32(bk.key)
+
+	put32(serialCookieNoRun)
+	put32(uint32(len(bk.containers)))
+
+	for _, c := range bk.containers {
+		put16(c.key)
+		put16(uint16(c.card - 1))
+	}
+
+	// Offsets are counted from the cookie
+	offset := 8 + 8*len(bk.containers)
+	for _, c := range bk.containers {
+		put32(uint32(offset))
+		offset += c.encodedSize()
+	}
+
+	for _, c := range bk.containers {
+		if c.card > arrayMaxSize {
+			buf.Write(c.bits)
+			continue
+		}
+
+		for i := 0; i < chunkBytes; i += 8 {
+			w := binary.LittleEndian.Uint64(c.bits[i:])
+
+			for w != 0 {
+				put16(uint16(i*bitsPerByte + bits.TrailingZeros64(w)))
+				w &= w - 1
+			}
+		}
+	}
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface
+func (b *Bitmap) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+
+	if _, err := b.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// WriteTo implements the io.WriterTo interface
+func (b *Bitmap) WriteTo(w io.Writer) (int64, error) {
+	b.mu.Lock()
+	buckets := b.buckets()
+	b.mu.Unlock()
+
+	var buf bytes.Buffer
+	var scratch [8]byte
+
+	binary.LittleEndian.PutUint64(scratch[:], uint64(len(buckets)))
+	buf.Write(scratch[:])
+
+	for i := range buckets {
+		writeBucket(&buf, &buckets[i])
+	}
+
+	return buf.WriteTo(w)
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+// The option of b is kept, bits it can't hold make the decoding fail.
+func (b *Bitmap) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+
+	d := b.decode(r)
+	if d.err != nil {
+		return d.err
+	}
+
+	if r.Len() != 0 {
+		return ErrInvalidFormat
+	}
+
+	b.replace(d.dst)
+
+	return nil
+}
+
+// ReadFrom implements the io.ReaderFrom interface. It replaces the content
+// of b, which is left untouched if the decoding fails.
+func (b *Bitmap) ReadFrom(r io.Reader) (int64, error) {
+	d := b.decode(r)
+	if d.err != nil {
+		return d.n, d.err
+	}
+
+	b.replace(d.dst)
+
+	return d.n, nil
+}
+
+// decode reads a serialized bitmap into a new Bitmap with the option of b
+func (b *Bitmap) decode(r io.Reader) *decoder {
+	b.mu.Lock()
+	option := b.option
+	b.mu.Unlock()
+
+	d := &decoder{r: r, dst: New(option)}
+	d.decode()
+
+	return d
+}
+
+// replace moves the content of other into b
+func (b *Bitmap) replace(other *Bitmap) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.pages = other.pages
+	b.size = other.size
+}
+
+type decoder struct {
+	r   io.Reader
+	n   int64
+	err error
+
+	dst     *Bitmap
+	page    *page // last page written
+	scratch [8]byte
+}
+
+func (d *decoder) read(p []byte) {
+	if d.err != nil {
+		return
+	}
+
+	n, err := io.ReadFull(d.r, p)
+	d.n += int64(n)
+
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = ErrInvalidFormat
+	}
+	d.err = err
+}
+
+func (d *decoder) u16() uint16 {
+	d.read(d.scratch[:2])
+	return binary.LittleEndian.Uint16(d.scratch[:])
+}
+
+func (d *decoder) u32() uint32 {
+	d.read(d.scratch[:4])
+	return binary.LittleEndian.Uint32(d.scratch[:])
+}
+
+func (d *decoder) u64() uint64 {
+	d.read(d.scratch[:8])
+	return binary.LittleEndian.Uint64(d.scratch[:])
+}
+
+func (d *decoder) fail() {
+	if d.err == nil {
+		d.err = ErrInvalidFormat
+	}
+}
+
+// set marks bit n, bits come in increasing order so the page of the
+// previous bit is tried first
+func (d *decoder) set(n uint64) {
+	if d.err != nil {
+		return
+	}
+
+	if !d.dst.inRange(n) {
+		d.err = ErrOutOfRange
+		return
+	}
+
+	if d.page == nil || d.page.id != d.dst.pageOf(n) {
+		_, d.page = d.dst.getPage(n, true)
+	}
+
+	idx := d.dst.offsetOf(n) / bitsPerByte
+	mask := uint8(1) << uint8(d.dst.offsetOf(n)%bitsPerByte)
+
+	if d.page.bits[idx]&mask == 0 {
+		d.page.bits[idx] |= mask
+		d.page.size++
+		d.dst.size++
+	}
+}
+
+func (d *decoder) decode() {
+	count := d.u64()
+	if count > 1<<32 {
+		d.fail()
+		return
+	}
+
+	var prev uint32
+
+	for i := uint64(0); i < count && d.err == nil; i++ {
+		key := d.u32()
+		if i > 0 && key <= prev {
+			d.fail()
+			return
+		}
+
+		d.decodeBucket(uint64(key) << 32)
+		prev = key
+	}
+}
+
+func (d *decoder) decodeBucket(hi uint64) {
+	var (
+		size       int
+		runFlags   []uint8
+		hasOffsets bool
+	)
+
+	cookie := d.u32()
+
+	switch {
+	case cookie == serialCookieNoRun:
+		n := d.u32()
+		if n > 1<<16 {
+			d.fail()
+			return
+		}
+
+		size = int(n)
+		hasOffsets = true
+
+	case cookie&0xffff == serialCookie:
+		size = int(cookie>>16) + 1
+		runFlags = make([]uint8, (size+7)/8)
+		d.read(runFlags)
+		hasOffsets = size >= noOffsetThreshold
+
+	default:
+		d.fail()
+		return
+	}
+
+	if d.err != nil {
+		return
+	}
+
+	header := make([]uint8, 4*size)
+	d.read(header)
+
+	// Containers are stored in order right after the headers,
+	// so the offsets aren't needed
+	if hasOffsets {
+		d.read(make([]uint8, 4*size))
+	}
+
+	for i := 0; i < size && d.err == nil; i++ {
+		key := binary.LittleEndian.Uint16(header[4*i:])
+		card := int(binary.LittleEndian.Uint16(header[4*i+2:])) + 1
+
+		if i > 0 && key <= binary.LittleEndian.Uint16(header[4*(i-1):]) {
+			d.fail()
+			return
+		}
+
+		base := hi | uint64(key)<<16
+
+		switch {
+		case runFlags != nil && runFlags[i/8]&(1<<uint(i%8)) != 0:
+			d.decodeRuns(base, card)
+		case card <= arrayMaxSize:
+			d.decodeArray(base, card)
+		default:
+			d.decodeBitset(base, card)
+		}
+	}
+}
+
+func (d *decoder) decodeArray(base uint64, card int) {
+	prev := -1
+
+	for i := 0; i < card && d.err == nil; i++ {
+		x := int(d.u16())
+		if x <= prev {
+			d.fail()
+			return
+		}
+
+		d.set(base + uint64(x))
+		prev = x
+	}
+}
+
+func (d *decoder) decodeBitset(base uint64, card int) {
+	bs := make([]uint8, chunkBytes)
+	d.read(bs)
+
+	if d.err != nil {
+		return
+	}
+
+	if popcount(bs) != card {
+		d.fail()
+		return
+	}
+
+	for i := 0; i < chunkBytes; i += 8 {
+		w := binary.LittleEndian.Uint64(bs[i:])
+
+		for w != 0 {
+			d.set(base + uint64(i*bitsPerByte+bits.TrailingZeros64(w)))
+			w &= w - 1
+		}
+	}
+}
+
+func (d *decoder) decodeRuns(base uint64, card int) {
+	runs := int(d.u16())
+	total := 0
+	next := 0 // lowest value the next run may start at
+
+	for i := 0; i < runs && d.err == nil; i++ {
+		start := int(d.u16())
+		last := start + int(d.u16())
+
+		if start < next || last > 0xffff {
+			d.fail()
+			return
+		}
+
+		for x := start; x <= last; x++ {
+			d.set(base + uint64(x))
+		}
+
+		total += last - start + 1
+		next = last + 1
+	}
+
+	if total != card {
+		d.fail()
+	}
+}
@@ -0,0 +1,234 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bitmap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// stream builds serialized bitmaps by hand
+type stream struct {
+	bytes.Buffer
+}
+
+func (s *stream) u8(xs ...uint8) *stream {
+	s.Write(xs)
+	return s
+}
+
+func (s *stream) u16(xs ...uint16) *stream {
+	for _, x := range xs {
+		binary.Write(s, binary.LittleEndian, x)
+	}
+	return s
+}
+
+func (s *stream) u32(xs ...uint32) *stream {
+	for _, x := range xs {
+		binary.Write(s, binary.LittleEndian, x)
+	}
+	return s
+}
+
+func (s *stream) u64(xs ...uint64) *stream {
+	for _, x := range xs {
+		binary.Write(s, binary.LittleEndian, x)
+	}
+	return s
+}
+
+func checkSameBits(t *testing.T, x, y *Bitmap) {
+	if x.Size() != y.Size() {
+		t.Fatalf("Size %d should equal to %d", x.Size(), y.Size())
+	}
+
+	it := x.Iterator()
+	for n, ok := it.Next(); ok; n, ok = it.Next() {
+		if !y.Test(n) {
+			t.Fatalf("%d should be set", n)
+		}
+	}
+}
+
+func TestBitmapSerializeFormat(t *testing.T) {
+	bm := newBitmapOf(nil, 0, 1, 1<<16)
+
+	expected := new(stream)
+	expected.u64(1).u32(0)             // one bucket with key 0
+	expected.u32(serialCookieNoRun, 2) // two containers
+	expected.u16(0, 1, 1, 0)           // keys and cardinalities minus one
+	expected.u32(24, 28)               // offsets
+	expected.u16(0, 1).u16(0)          // array containers
+
+	data, err := bm.MarshalBinary()
+	if err != nil || !bytes.Equal(data, expected.Bytes()) {
+		t.Fatalf("Unexpected encoding %v", data)
+	}
+
+	other := New(nil)
+	if err := other.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+
+	checkSameBits(t, bm, other)
+}
+
+func TestBitmapSerializeRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	bm := New(nil)
+
+	// A dense chunk is written as a bitmap container
+	for i := 0; i < 3*arrayMaxSize; i++ {
+		bm.Set(uint64(r.Intn(1 << 16)))
+	}
+
+	for i := 0; i < 1000; i++ {
+		bm.Set(uint64(r.Int63()))
+	}
+
+	bm.Set(math.MaxUint64)
+
+	var buf bytes.Buffer
+
+	n, err := bm.WriteTo(&buf)
+	if err != nil || n != int64(buf.Len()) {
+		t.Fatal("Failed to write the bitmap")
+	}
+
+	other := newBitmapOf(nil, 12345)
+
+	m, err := other.ReadFrom(&buf)
+	if err != nil || m != n {
+		t.Fatal("Failed to read the bitmap back")
+	}
+
+	checkSameBits(t, bm, other)
+	checkCounts(t, other)
+}
+
+func TestBitmapDeserializeRuns(t *testing.T) {
+	data := new(stream)
+	data.u64(1).u32(7)
+	data.u32(serialCookie | 1<<16) // two containers
+	data.u8(1)                     // the first one is a run container
+	data.u16(0, 8, 3, 0)           // keys and cardinalities minus one
+	data.u16(2, 5, 3, 100, 4)      // runs [5, 8] and [100, 104]
+	data.u16(42)                   // array container
+
+	bm := New(nil)
+	if err := bm.UnmarshalBinary(data.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	hi := uint64(7) << 32
+	expected := []uint64{hi + 5, hi + 6, hi + 7, hi + 8, hi + 100, hi + 101,
+		hi + 102, hi + 103, hi + 104, hi + 3<<16 + 42}
+
+	checkBitmap(t, "runs", bm, expected...)
+}
+
+func TestBitmapDeserializeInvalid(t *testing.T) {
+	bm := newBitmapOf(&Option{Capacity: 100}, 5)
+
+	data, _ := newBitmapOf(nil, 100).MarshalBinary()
+
+	if err := bm.UnmarshalBinary(data); err != ErrOutOfRange {
+		t.Fatal("Bits beyond the capacity should be rejected")
+	}
+
+	if err := bm.UnmarshalBinary(data[:len(data)-1]); err != ErrInvalidFormat {
+		t.Fatal("Truncated data should be rejected")
+	}
+
+	data, _ = newBitmapOf(nil, 50).MarshalBinary()
+
+	if err := bm.UnmarshalBinary(append(data, 0)); err != ErrInvalidFormat {
+		t.Fatal("Trailing data should be rejected")
+	}
+
+	checkBitmap(t, "untouched", bm, 5)
+}
+
+func FuzzBitmapUnmarshalBinary(f *testing.F) {
+	for _, ns := range [][]uint64{
+		{},
+		{0, 1, 1 << 16},
+		{math.MaxUint64, 1 << 40},
+	} {
+		data, _ := newBitmapOf(nil, ns...).MarshalBinary()
+		f.Add(data)
+	}
+
+	dense := New(nil)
+	for i := uint64(0); i < 2*arrayMaxSize; i++ {
+		dense.Set(i * 3)
+	}
+	data, _ := dense.MarshalBinary()
+	f.Add(data)
+
+	runs := new(stream)
+	runs.u64(1).u32(0).u32(serialCookie).u8(1).u16(0, 3).u16(1, 0, 3)
+	f.Add(runs.Bytes())
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		bm := New(nil)
+		if err := bm.UnmarshalBinary(data); err != nil {
+			return
+		}
+
+		checkCounts(t, bm)
+
+		encoded, err := bm.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		other := New(nil)
+		if err := other.UnmarshalBinary(encoded); err != nil {
+			t.Fatal(err)
+		}
+
+		checkSameBits(t, bm, other)
+	})
+}
+
+func TestBitmapSerializePageSize(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	small := New(&Option{AutoExpand: true, PageSize: 24})
+	for i := 0; i < 5000; i++ {
+		small.Set(uint64(r.Intn(1 << 18)))
+	}
+
+	data, err := small.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bm := New(nil)
+	if err := bm.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+
+	checkSameBits(t, small, bm)
+
+	// The encoding doesn't depend on the page size
+	if other, _ := bm.MarshalBinary(); !bytes.Equal(data, other) {
+		t.Fatal("Both page sizes should give the same encoding")
+	}
+
+	big := New(&Option{AutoExpand: true, PageSize: 1 << 15})
+	if err := big.UnmarshalBinary(data); err != nil || big.pageSize != 1<<15 {
+		t.Fatal("Decoding should keep the page size of the target")
+	}
+
+	checkSameBits(t, small, big)
+	checkCounts(t, big)
+}
@@ -0,0 +1,273 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package bloom provides Bloom filters, sets which answer membership
+// queries with either "definitely not in" or "probably in".
+package bloom
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/flatpeach/coconut/hash/murmur3"
+	"math"
+	"math/bits"
+	"sync"
+)
+
+var (
+	ErrNotCompatible = errors.New("bloom: filters have different shapes")
+	ErrInvalidFormat = errors.New("bloom: invalid serialized filter")
+)
+
+// Filter is the standard Bloom filter
+type Filter struct {
+	mu sync.Mutex
+
+	m     uint64   // bits in the filter
+	k     uint64   // hash functions
+	words []uint64 // the bit array, bit i is words[i/64]>>(i%64)
+}
+
+const bitsPerWord = 64
+
+// estimate returns the optimal bits and hash functions count for n items
+// at the false positive rate p
+func estimate(n uint64, p float64) (uint64, uint64) {
+	m := math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2))
+	k := math.Round(m / float64(n) * math.Ln2)
+
+	if k < 1 {
+		k = 1
+	}
+
+	return uint64(m), uint64(k)
+}
+
+// Return back a new Filter sized according the option passed in
+func New(option *Option) *Filter {
+	if option == nil {
+		option = &Option{}
+	}
+
+	option = option.normalize()
+
+	m, k := estimate(option.Items, option.FalsePositiveRate)
+
+	return newFilter(m, k)
+}
+
+func newFilter(m, k uint64) *Filter {
+	return &Filter{
+		m:     m,
+		k:     k,
+		words: make([]uint64, (m+bitsPerWord-1)/bitsPerWord),
+	}
+}
+
+// hashes returns the two base hashes of data, the i-th location is
+// derived from them by double hashing
+func hashes(data []byte) (uint64, uint64) {
+	return murmur3.Sum128(data, 0)
+}
+
+// location returns the bit hit by the i-th hash function
+func location(h1, h2, i, m uint64) uint64 {
+	return (h1 + i*h2) % m
+}
+
+// Add one item into the filter
+func (f *Filter) Add(data []byte) {
+	h1, h2 := hashes(data)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.add(h1, h2)
+}
+
+func (f *Filter) add(h1, h2 uint64) {
+	for i := uint64(0); i < f.k; i++ {
+		l := location(h1, h2, i, f.m)
+		f.words[l/bitsPerWord] |= 1 << (l % bitsPerWord)
+	}
+}
+
+// Test whether one item is probably in the filter
+func (f *Filter) Test(data []byte) bool {
+	h1, h2 := hashes(data)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.test(h1, h2)
+}
+
+func (f *Filter) test(h1, h2 uint64) bool {
+	for i := uint64(0); i < f.k; i++ {
+		l := location(h1, h2, i, f.m)
+		if f.words[l/bitsPerWord]&(1<<(l%bitsPerWord)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// AddString adds one string into the filter
+func (f *Filter) AddString(s string) {
+	f.Add([]byte(s))
+}
+
+// TestString tests whether one string is probably in the filter
+func (f *Filter) TestString(s string) bool {
+	return f.Test([]byte(s))
+}
+
+// Cap returns how many bits the filter holds
+func (f *Filter) Cap() uint64 {
+	return f.m
+}
+
+// K returns how many hash functions the filter uses
+func (f *Filter) K() uint64 {
+	return f.k
+}
+
+// Reinit the whole filter
+func (f *Filter) ClearAll() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i := range f.words {
+		f.words[i] = 0
+	}
+}
+
+// snapshot returns a copy of the bits, checking the shape matches f
+func (f *Filter) snapshot(other *Filter) ([]uint64, error) {
+	other.mu.Lock()
+	defer other.mu.Unlock()
+
+	if other.m != f.m || other.k != f.k {
+		return nil, ErrNotCompatible
+	}
+
+	return append([]uint64(nil), other.words...), nil
+}
+
+// Union adds all items of other into f, both must have the same shape
+func (f *Filter) Union(other *Filter) error {
+	words, err := f.snapshot(other)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i, w := range words {
+		f.words[i] |= w
+	}
+
+	return nil
+}
+
+// Intersect keeps in f only the bits also set in other, both must have the
+// same shape. Items in both filters are still found, the false positive
+// rate is at least the one of the smaller filter.
+func (f *Filter) Intersect(other *Filter) error {
+	words, err := f.snapshot(other)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i, w := range words {
+		f.words[i] &= w
+	}
+
+	return nil
+}
+
+// ones returns how many bits are set
+func (f *Filter) ones() uint64 {
+	n := 0
+	for _, w := range f.words {
+		n += bits.OnesCount64(w)
+	}
+
+	return uint64(n)
+}
+
+// FalsePositiveRate estimates the current false positive rate from how
+// many bits are set
+func (f *Filter) FalsePositiveRate() float64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.falsePositiveRate()
+}
+
+func (f *Filter) falsePositiveRate() float64 {
+	return math.Pow(float64(f.ones())/float64(f.m), float64(f.k))
+}
+
+// Filters are serialized as the hash functions count and the bits count,
+// followed by the words of the bit array, all little endian uint64s.
+const headerSize = 16
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface
+func (f *Filter) MarshalBinary() ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data := make([]byte, headerSize+8*len(f.words))
+
+	binary.LittleEndian.PutUint64(data, f.k)
+	binary.LittleEndian.PutUint64(data[8:], f.m)
+
+	for i, w := range f.words {
+		binary.LittleEndian.PutUint64(data[headerSize+8*i:], w)
+	}
+
+	return data, nil
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface
+func (f *Filter) UnmarshalBinary(data []byte) error {
+	if len(data) < headerSize {
+		return ErrInvalidFormat
+	}
+
+	k := binary.LittleEndian.Uint64(data)
+	m := binary.LittleEndian.Uint64(data[8:])
+
+	if k == 0 || m == 0 || m > math.MaxUint64-bitsPerWord {
+		return ErrInvalidFormat
+	}
+
+	n := (m + bitsPerWord - 1) / bitsPerWord
+	if uint64(len(data)-headerSize)/8 != n || (len(data)-headerSize)%8 != 0 {
+		return ErrInvalidFormat
+	}
+
+	words := make([]uint64, n)
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint64(data[headerSize+8*i:])
+	}
+
+	// Bits beyond m can't be set
+	if m%bitsPerWord != 0 && words[n-1]>>(m%bitsPerWord) != 0 {
+		return ErrInvalidFormat
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.m, f.k, f.words = m, k, words
+
+	return nil
+}
@@ -0,0 +1,199 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bloom
+
+import (
+	"strconv"
+	"testing"
+)
+
+func key(i int) string {
+	return "key-" + strconv.Itoa(i)
+}
+
+// falsePositives returns the rate of never added keys found in the filter
+func falsePositives(test func(string) bool, from, n int) float64 {
+	fp := 0
+	for i := from; i < from+n; i++ {
+		if test(key(i)) {
+			fp++
+		}
+	}
+
+	return float64(fp) / float64(n)
+}
+
+func TestFilterEstimate(t *testing.T) {
+	f := New(&Option{Items: 1000, FalsePositiveRate: 0.01})
+	if f.Cap() != 9586 || f.K() != 7 {
+		t.Fatalf("Should have 9586 bits and 7 hashes, got %d and %d", f.Cap(), f.K())
+	}
+
+	f = New(nil)
+	if f.Cap() == 0 || f.K() == 0 {
+		t.Fatal("Default filter should be usable")
+	}
+
+	f = New(&Option{Items: 10, FalsePositiveRate: 2})
+	if g := New(&Option{Items: 10, FalsePositiveRate: DefaultFalsePositiveRate}); f.Cap() != g.Cap() {
+		t.Fatal("Invalid rate should fall back to the default")
+	}
+}
+
+func TestFilter(t *testing.T) {
+	const n = 10000
+
+	for _, p := range []float64{0.1, 0.01, 0.001} {
+		f := New(&Option{Items: n, FalsePositiveRate: p})
+
+		for i := 0; i < n; i++ {
+			f.AddString(key(i))
+		}
+
+		for i := 0; i < n; i++ {
+			if !f.TestString(key(i)) {
+				t.Fatalf("%s should be in the filter", key(i))
+			}
+		}
+
+		if r := falsePositives(f.TestString, n, 100000); r > p*1.5 {
+			t.Fatalf("False positive rate %f should be around %f", r, p)
+		}
+
+		if r := f.FalsePositiveRate(); r > p*1.5 || r < p/1.5 {
+			t.Fatalf("Estimated false positive rate %f should be around %f", r, p)
+		}
+	}
+}
+
+func TestFilterClearAll(t *testing.T) {
+	f := New(&Option{Items: 100})
+	f.Add([]byte("x"))
+	f.ClearAll()
+
+	if f.Test([]byte("x")) || f.FalsePositiveRate() != 0 {
+		t.Fatal("Filter should be empty")
+	}
+}
+
+func TestFilterUnion(t *testing.T) {
+	x := New(&Option{Items: 1000})
+	y := New(&Option{Items: 1000})
+
+	for i := 0; i < 500; i++ {
+		x.AddString(key(i))
+		y.AddString(key(i + 500))
+	}
+
+	if err := x.Union(y); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 1000; i++ {
+		if !x.TestString(key(i)) {
+			t.Fatalf("%s should be in the union", key(i))
+		}
+	}
+
+	if err := x.Union(New(&Option{Items: 10})); err != ErrNotCompatible {
+		t.Fatal("Union of different shapes should fail")
+	}
+}
+
+func TestFilterIntersect(t *testing.T) {
+	x := New(&Option{Items: 1000})
+	y := New(&Option{Items: 1000})
+
+	for i := 0; i < 1000; i++ {
+		x.AddString(key(i))
+		y.AddString(key(i + 500))
+	}
+
+	if err := x.Intersect(y); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 500; i < 1000; i++ {
+		if !x.TestString(key(i)) {
+			t.Fatalf("%s should be in the intersection", key(i))
+		}
+	}
+
+	if r := falsePositives(x.TestString, 0, 500); r > 0.1 {
+		t.Fatalf("Too many items only in x are left: %f", r)
+	}
+
+	if err := x.Intersect(New(&Option{Items: 10})); err != ErrNotCompatible {
+		t.Fatal("Intersect of different shapes should fail")
+	}
+}
+
+func TestFilterSerialize(t *testing.T) {
+	f := New(&Option{Items: 1000, FalsePositiveRate: 0.001})
+	for i := 0; i < 1000; i++ {
+		f.AddString(key(i))
+	}
+
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := New(nil)
+	if err := g.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if g.Cap() != f.Cap() || g.K() != f.K() {
+		t.Fatal("Shape should survive serialization")
+	}
+
+	for i := 0; i < 2000; i++ {
+		if f.TestString(key(i)) != g.TestString(key(i)) {
+			t.Fatalf("%s should test the same", key(i))
+		}
+	}
+
+	invalid := [][]byte{
+		nil,
+		data[:headerSize],
+		data[:len(data)-1],
+		append(append([]byte(nil), data...), 0),
+	}
+
+	// Zero hash functions
+	zero := append([]byte(nil), data...)
+	zero[0] = 0
+	invalid = append(invalid, zero)
+
+	// One bit past the end
+	past := append([]byte(nil), data...)
+	past[len(past)-1] = 0x80
+	invalid = append(invalid, past)
+
+	for i, data := range invalid {
+		if err := g.UnmarshalBinary(data); err != ErrInvalidFormat {
+			t.Fatalf("Case %d should be rejected, got %v", i, err)
+		}
+	}
+}
+
+func BenchmarkFilterAdd(b *testing.B) {
+	f := New(&Option{Items: uint64(b.N)})
+	data := []byte("0123456789abcdef")
+
+	for i := 0; i < b.N; i++ {
+		f.Add(data)
+	}
+}
+
+func BenchmarkFilterTest(b *testing.B) {
+	f := New(&Option{Items: 1000})
+	data := []byte("0123456789abcdef")
+
+	for i := 0; i < b.N; i++ {
+		f.Test(data)
+	}
+}
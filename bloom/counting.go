@@ -0,0 +1,209 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bloom
+
+import (
+	"math"
+	"sync"
+)
+
+// Counting is a Bloom filter supporting removal, every bit is replaced by
+// a small counter.
+//
+// A counter reaching its maximum value saturates: it is never incremented
+// nor decremented anymore, since its real value is unknown. This may leave
+// removed items in the filter, but never loses an item still in.
+type Counting struct {
+	mu sync.Mutex
+
+	m     uint64 // counters in the filter
+	k     uint64 // hash functions
+	width uint   // bits of one counter
+	max   uint64 // saturated counter value
+
+	counters  []uint64 // packed counters, 64/width per word
+	overflows uint64   // times a counter saturated
+}
+
+// Return back a new Counting filter sized according the option passed in
+func NewCounting(option *Option) *Counting {
+	if option == nil {
+		option = &Option{}
+	}
+
+	option = option.normalize()
+
+	m, k := estimate(option.Items, option.FalsePositiveRate)
+	perWord := bitsPerWord / uint64(option.CounterWidth)
+
+	return &Counting{
+		m:        m,
+		k:        k,
+		width:    option.CounterWidth,
+		max:      1<<option.CounterWidth - 1,
+		counters: make([]uint64, (m+perWord-1)/perWord),
+	}
+}
+
+// slot returns the word and the shift of counter i
+func (c *Counting) slot(i uint64) (int, uint) {
+	perWord := bitsPerWord / uint64(c.width)
+	return int(i / perWord), uint(i%perWord) * c.width
+}
+
+func (c *Counting) get(i uint64) uint64 {
+	w, shift := c.slot(i)
+	return c.counters[w] >> shift & c.max
+}
+
+func (c *Counting) set(i, v uint64) {
+	w, shift := c.slot(i)
+	c.counters[w] = c.counters[w]&^(c.max<<shift) | v<<shift
+}
+
+// Add one item into the filter, reports false if some of its counters
+// saturated and can't be decremented anymore
+func (c *Counting) Add(data []byte) bool {
+	h1, h2 := hashes(data)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ok := true
+
+	for i := uint64(0); i < c.k; i++ {
+		l := location(h1, h2, i, c.m)
+
+		v := c.get(l)
+		if v == c.max {
+			ok = false
+			continue
+		}
+
+		c.set(l, v+1)
+
+		if v+1 == c.max {
+			c.overflows++
+			ok = false
+		}
+	}
+
+	return ok
+}
+
+// Remove one item from the filter, reports false and keeps the filter
+// untouched if the item is definitely not in. Removing an item never added
+// may remove other items.
+func (c *Counting) Remove(data []byte) bool {
+	h1, h2 := hashes(data)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.test(h1, h2) {
+		return false
+	}
+
+	for i := uint64(0); i < c.k; i++ {
+		l := location(h1, h2, i, c.m)
+
+		if v := c.get(l); v != c.max {
+			c.set(l, v-1)
+		}
+	}
+
+	return true
+}
+
+func (c *Counting) test(h1, h2 uint64) bool {
+	for i := uint64(0); i < c.k; i++ {
+		if c.get(location(h1, h2, i, c.m)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Test whether one item is probably in the filter
+func (c *Counting) Test(data []byte) bool {
+	h1, h2 := hashes(data)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.test(h1, h2)
+}
+
+// AddString adds one string into the filter
+func (c *Counting) AddString(s string) bool {
+	return c.Add([]byte(s))
+}
+
+// RemoveString removes one string from the filter
+func (c *Counting) RemoveString(s string) bool {
+	return c.Remove([]byte(s))
+}
+
+// TestString tests whether one string is probably in the filter
+func (c *Counting) TestString(s string) bool {
+	return c.Test([]byte(s))
+}
+
+// Cap returns how many counters the filter holds
+func (c *Counting) Cap() uint64 {
+	return c.m
+}
+
+// K returns how many hash functions the filter uses
+func (c *Counting) K() uint64 {
+	return c.k
+}
+
+// Width returns the bits of one counter
+func (c *Counting) Width() uint {
+	return c.width
+}
+
+// Overflows returns how many times a counter saturated
+func (c *Counting) Overflows() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.overflows
+}
+
+// Cardinality estimates how many distinct items are in the filter from
+// the count of non zero counters
+func (c *Counting) Cardinality() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	used := uint64(0)
+	for i := uint64(0); i < c.m; i++ {
+		if c.get(i) != 0 {
+			used++
+		}
+	}
+
+	if used == c.m {
+		return math.MaxUint64
+	}
+
+	m, k := float64(c.m), float64(c.k)
+	return uint64(math.Round(-m / k * math.Log(1-float64(used)/m)))
+}
+
+// Reinit the whole filter
+func (c *Counting) ClearAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := range c.counters {
+		c.counters[i] = 0
+	}
+
+	c.overflows = 0
+}
@@ -0,0 +1,140 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bloom
+
+import (
+	"testing"
+)
+
+func TestCountingWidth(t *testing.T) {
+	for _, c := range []struct{ in, out uint }{
+		{0, 4}, {3, 4}, {4, 4}, {5, 8}, {8, 8}, {9, 16}, {16, 16}, {32, 16},
+	} {
+		if w := NewCounting(&Option{CounterWidth: c.in}).Width(); w != c.out {
+			t.Fatalf("Width %d should become %d, got %d", c.in, c.out, w)
+		}
+	}
+}
+
+func TestCounting(t *testing.T) {
+	const n = 10000
+
+	for _, width := range []uint{4, 8, 16} {
+		c := NewCounting(&Option{Items: n, FalsePositiveRate: 0.01, CounterWidth: width})
+
+		for i := 0; i < n; i++ {
+			c.AddString(key(i))
+		}
+
+		// Remove the even keys, the odd ones must all stay
+		for i := 0; i < n; i += 2 {
+			if !c.RemoveString(key(i)) {
+				t.Fatalf("%s should be removed", key(i))
+			}
+		}
+
+		for i := 1; i < n; i += 2 {
+			if !c.TestString(key(i)) {
+				t.Fatalf("%s should be in the filter", key(i))
+			}
+		}
+
+		if r := falsePositives(c.TestString, 0, n); r > 0.55 {
+			t.Fatalf("Too many removed keys are still found: %f", r)
+		}
+
+		if r := falsePositives(c.TestString, n, 100000); r > 0.01 {
+			t.Fatalf("False positive rate %f should be below 0.01 at half load", r)
+		}
+	}
+}
+
+func TestCountingRemoveAbsent(t *testing.T) {
+	c := NewCounting(&Option{Items: 100})
+	c.AddString("x")
+
+	if c.RemoveString("y") {
+		t.Fatal("y was never added")
+	}
+
+	if !c.TestString("x") {
+		t.Fatal("x should be untouched")
+	}
+
+	if !c.RemoveString("x") || c.TestString("x") || c.Cardinality() != 0 {
+		t.Fatal("Filter should be empty")
+	}
+}
+
+func TestCountingSaturate(t *testing.T) {
+	c := NewCounting(&Option{Items: 100, CounterWidth: 4})
+
+	// 4 bits counters saturate at 15
+	for i := 0; i < 14; i++ {
+		if !c.AddString("x") {
+			t.Fatalf("Add %d should not saturate", i)
+		}
+	}
+
+	if c.AddString("x") {
+		t.Fatal("15th add should saturate")
+	}
+
+	if c.Overflows() == 0 {
+		t.Fatal("Overflow should be reported")
+	}
+
+	if c.AddString("x") {
+		t.Fatal("Adding into saturated counters should be reported")
+	}
+
+	// Saturated counters stick, x is never lost
+	for i := 0; i < 100; i++ {
+		c.RemoveString("x")
+	}
+
+	if !c.TestString("x") {
+		t.Fatal("x should stay in saturated counters")
+	}
+
+	c.ClearAll()
+	if c.TestString("x") || c.Overflows() != 0 {
+		t.Fatal("Filter should be empty")
+	}
+}
+
+func TestCountingCardinality(t *testing.T) {
+	const n = 10000
+
+	c := NewCounting(&Option{Items: n, CounterWidth: 8})
+
+	for i := 0; i < n; i++ {
+		c.AddString(key(i))
+		c.AddString(key(i)) // counted once
+	}
+
+	if e := c.Cardinality(); e < n*95/100 || e > n*105/100 {
+		t.Fatalf("Cardinality %d should be around %d", e, n)
+	}
+
+	for i := 0; i < n/2; i++ {
+		c.RemoveString(key(i))
+		c.RemoveString(key(i))
+	}
+
+	if e := c.Cardinality(); e < n/2*95/100 || e > n/2*105/100 {
+		t.Fatalf("Cardinality %d should be around %d", e, n/2)
+	}
+}
+
+func BenchmarkCountingAdd(b *testing.B) {
+	c := NewCounting(&Option{Items: uint64(b.N), CounterWidth: 16})
+	data := []byte("0123456789abcdef")
+
+	for i := 0; i < b.N; i++ {
+		c.Add(data)
+		c.Remove(data)
+	}
+}
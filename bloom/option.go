@@ -0,0 +1,76 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bloom
+
+// Option used to size the filters
+type Option struct {
+	// Expected count of items to be added, zero is treated as one
+	Items uint64
+
+	// Target false positive rate once Items items are added, anything
+	// outside (0, 1) means DefaultFalsePositiveRate
+	FalsePositiveRate float64
+
+	// Bits of one counter of the Counting filter, 4, 8 or 16. Zero means 4,
+	// other widths are rounded up to the next supported one.
+	CounterWidth uint
+
+	// How much bigger each filter of the Scalable filter is than the
+	// previous one, zero means DefaultGrowthFactor
+	GrowthFactor uint64
+
+	// How much lower the false positive rate of each filter of the Scalable
+	// filter is than the previous one, anything outside (0, 1) means
+	// DefaultTighteningRatio
+	TighteningRatio float64
+}
+
+const (
+	DefaultFalsePositiveRate = 0.01
+	DefaultGrowthFactor      = 2
+	DefaultTighteningRatio   = 0.85
+)
+
+func (o *Option) clone() *Option {
+	return &Option{
+		Items:             o.Items,
+		FalsePositiveRate: o.FalsePositiveRate,
+		CounterWidth:      o.CounterWidth,
+		GrowthFactor:      o.GrowthFactor,
+		TighteningRatio:   o.TighteningRatio,
+	}
+}
+
+// normalize fixes the zero values and out of range rates
+func (o *Option) normalize() *Option {
+	o = o.clone()
+
+	if o.Items == 0 {
+		o.Items = 1
+	}
+
+	if !(o.FalsePositiveRate > 0 && o.FalsePositiveRate < 1) {
+		o.FalsePositiveRate = DefaultFalsePositiveRate
+	}
+
+	if o.GrowthFactor == 0 {
+		o.GrowthFactor = DefaultGrowthFactor
+	}
+
+	if !(o.TighteningRatio > 0 && o.TighteningRatio < 1) {
+		o.TighteningRatio = DefaultTighteningRatio
+	}
+
+	switch {
+	case o.CounterWidth <= 4:
+		o.CounterWidth = 4
+	case o.CounterWidth <= 8:
+		o.CounterWidth = 8
+	default:
+		o.CounterWidth = 16
+	}
+
+	return o
+}
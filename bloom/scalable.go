@@ -0,0 +1,270 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bloom
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+)
+
+// Scalable is a Bloom filter growing with the items added, see "Scalable
+// Bloom Filters" by Almeida, Baquero, Preguiça and Hutchison.
+//
+// It chains standard filters. Once the last one holds the items it was sized
+// for, a new one GrowthFactor times bigger is appended, its false positive
+// rate being TighteningRatio times lower. The rates form a geometric series,
+// so the compound rate stays below FalsePositiveRate whatever the count of
+// items is.
+type Scalable struct {
+	mu sync.Mutex
+
+	rate   float64 // target compound false positive rate
+	ratio  float64 // tightening ratio of the rates
+	growth uint64  // growth factor of the sizes
+
+	stages []*stage
+	count  uint64 // total items added
+}
+
+// stage is one filter of the chain
+type stage struct {
+	filter *Filter
+	items  uint64 // items the filter is sized for
+	count  uint64 // items added into the filter
+}
+
+// Return back a new Scalable filter according the option passed in,
+// Items is the count of items of the first filter of the chain
+func NewScalable(option *Option) *Scalable {
+	if option == nil {
+		option = &Option{}
+	}
+
+	option = option.normalize()
+
+	s := &Scalable{
+		rate:   option.FalsePositiveRate,
+		ratio:  option.TighteningRatio,
+		growth: option.GrowthFactor,
+	}
+
+	s.grow(option.Items)
+
+	return s
+}
+
+// stageRate returns the false positive rate of the i-th filter, the rates
+// of all filters sum up to s.rate
+func (s *Scalable) stageRate(i int) float64 {
+	return s.rate * (1 - s.ratio) * math.Pow(s.ratio, float64(i))
+}
+
+// grow appends one filter sized for items
+func (s *Scalable) grow(items uint64) {
+	m, k := estimate(items, s.stageRate(len(s.stages)))
+
+	s.stages = append(s.stages, &stage{
+		filter: newFilter(m, k),
+		items:  items,
+	})
+}
+
+func (s *Scalable) test(h1, h2 uint64) bool {
+	for _, st := range s.stages {
+		if st.filter.test(h1, h2) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Add one item into the filter, reports false if it's probably in already
+func (s *Scalable) Add(data []byte) bool {
+	h1, h2 := hashes(data)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.test(h1, h2) {
+		return false
+	}
+
+	last := s.stages[len(s.stages)-1]
+	if last.count >= last.items {
+		items := last.items * s.growth
+		if items/s.growth != last.items {
+			items = math.MaxUint64
+		}
+
+		s.grow(items)
+		last = s.stages[len(s.stages)-1]
+	}
+
+	last.filter.add(h1, h2)
+	last.count++
+	s.count++
+
+	return true
+}
+
+// Test whether one item is probably in the filter
+func (s *Scalable) Test(data []byte) bool {
+	h1, h2 := hashes(data)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.test(h1, h2)
+}
+
+// AddString adds one string into the filter
+func (s *Scalable) AddString(str string) bool {
+	return s.Add([]byte(str))
+}
+
+// TestString tests whether one string is probably in the filter
+func (s *Scalable) TestString(str string) bool {
+	return s.Test([]byte(str))
+}
+
+// Count returns how many items were added
+func (s *Scalable) Count() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.count
+}
+
+// Stages returns how many filters are chained
+func (s *Scalable) Stages() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.stages)
+}
+
+// FalsePositiveRate estimates the current compound false positive rate
+// from how many bits are set in every filter
+func (s *Scalable) FalsePositiveRate() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ok := 1.0
+	for _, st := range s.stages {
+		ok *= 1 - st.filter.falsePositiveRate()
+	}
+
+	return 1 - ok
+}
+
+// Scalable filters are serialized as the target rate, the tightening ratio,
+// the growth factor and the count of filters. Each filter follows with the
+// items it's sized for, the items added and the length of the serialized
+// standard filter before it. All numbers are little endian 64 bits.
+const (
+	scalableHeaderSize = 32
+	stageHeaderSize    = 24
+)
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface
+func (s *Scalable) MarshalBinary() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var scratch [8]byte
+	data := make([]byte, 0, scalableHeaderSize)
+
+	put64 := func(x uint64) {
+		binary.LittleEndian.PutUint64(scratch[:], x)
+		data = append(data, scratch[:]...)
+	}
+
+	put64(math.Float64bits(s.rate))
+	put64(math.Float64bits(s.ratio))
+	put64(s.growth)
+	put64(uint64(len(s.stages)))
+
+	for _, st := range s.stages {
+		f, err := st.filter.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+
+		put64(st.items)
+		put64(st.count)
+		put64(uint64(len(f)))
+		data = append(data, f...)
+	}
+
+	return data, nil
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface
+func (s *Scalable) UnmarshalBinary(data []byte) error {
+	if len(data) < scalableHeaderSize {
+		return ErrInvalidFormat
+	}
+
+	x := &Scalable{
+		rate:   math.Float64frombits(binary.LittleEndian.Uint64(data)),
+		ratio:  math.Float64frombits(binary.LittleEndian.Uint64(data[8:])),
+		growth: binary.LittleEndian.Uint64(data[16:]),
+	}
+
+	n := binary.LittleEndian.Uint64(data[24:])
+	data = data[scalableHeaderSize:]
+
+	if !(x.rate > 0 && x.rate < 1) || !(x.ratio > 0 && x.ratio < 1) ||
+		x.growth == 0 || n == 0 || n > uint64(len(data))/stageHeaderSize {
+		return ErrInvalidFormat
+	}
+
+	for i := uint64(0); i < n; i++ {
+		if len(data) < stageHeaderSize {
+			return ErrInvalidFormat
+		}
+
+		st := &stage{
+			filter: &Filter{},
+			items:  binary.LittleEndian.Uint64(data),
+			count:  binary.LittleEndian.Uint64(data[8:]),
+		}
+
+		size := binary.LittleEndian.Uint64(data[16:])
+		data = data[stageHeaderSize:]
+
+		if st.items == 0 || st.count > st.items || size > uint64(len(data)) {
+			return ErrInvalidFormat
+		}
+
+		// Only the last filter can have room left
+		if i+1 < n && st.count != st.items {
+			return ErrInvalidFormat
+		}
+
+		if err := st.filter.UnmarshalBinary(data[:size]); err != nil {
+			return err
+		}
+
+		data = data[size:]
+
+		x.stages = append(x.stages, st)
+		x.count += st.count
+	}
+
+	if len(data) != 0 {
+		return ErrInvalidFormat
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rate, s.ratio, s.growth = x.rate, x.ratio, x.growth
+	s.stages, s.count = x.stages, x.count
+
+	return nil
+}
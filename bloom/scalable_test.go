@@ -0,0 +1,131 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bloom
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestScalable(t *testing.T) {
+	const n = 100000
+
+	s := NewScalable(&Option{Items: 1000, FalsePositiveRate: 0.01})
+
+	if s.Stages() != 1 {
+		t.Fatal("Should start with one filter")
+	}
+
+	for i := 0; i < n; i++ {
+		s.AddString(key(i))
+
+		// The compound rate is bounded all along the growth
+		if i%10000 == 0 {
+			if r := s.FalsePositiveRate(); r > 0.01 {
+				t.Fatalf("Estimated false positive rate %f should stay below 0.01 at %d items", r, i)
+			}
+		}
+	}
+
+	// 1000 * (1 + 2 + ... + 64) holds 127000 items
+	if s.Stages() != 7 {
+		t.Fatalf("Should have 7 filters, got %d", s.Stages())
+	}
+
+	if s.Count() > n || s.Count() < n*99/100 {
+		t.Fatalf("Count %d should be around %d", s.Count(), n)
+	}
+
+	for i := 0; i < n; i++ {
+		if !s.TestString(key(i)) {
+			t.Fatalf("%s should be in the filter", key(i))
+		}
+	}
+
+	if r := falsePositives(s.TestString, n, 100000); r > 0.01 {
+		t.Fatalf("False positive rate %f should stay below 0.01", r)
+	}
+}
+
+func TestScalableAddTwice(t *testing.T) {
+	s := NewScalable(nil)
+
+	if !s.AddString("x") {
+		t.Fatal("x should be added")
+	}
+
+	if s.AddString("x") || s.Count() != 1 {
+		t.Fatal("x should be added once")
+	}
+}
+
+func TestScalableSerialize(t *testing.T) {
+	s := NewScalable(&Option{Items: 100, FalsePositiveRate: 0.001, GrowthFactor: 4, TighteningRatio: 0.5})
+	for i := 0; i < 1000; i++ {
+		s.AddString(key(i))
+	}
+
+	data, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x := NewScalable(nil)
+	if err := x.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if x.Stages() != s.Stages() || x.Count() != s.Count() || x.FalsePositiveRate() != s.FalsePositiveRate() {
+		t.Fatal("Chain should survive serialization")
+	}
+
+	// Both keep growing the same way
+	for i := 1000; i < 5000; i++ {
+		if s.AddString(key(i)) != x.AddString(key(i)) {
+			t.Fatalf("%s should be added the same", key(i))
+		}
+	}
+
+	for i := 0; i < 10000; i++ {
+		if s.TestString(key(i)) != x.TestString(key(i)) {
+			t.Fatalf("%s should test the same", key(i))
+		}
+	}
+
+	patch := func(off int, v uint64) []byte {
+		d := append([]byte(nil), data...)
+		binary.LittleEndian.PutUint64(d[off:], v)
+		return d
+	}
+
+	invalid := [][]byte{
+		nil,
+		data[:scalableHeaderSize],
+		data[:len(data)-1],
+		append(append([]byte(nil), data...), 0),
+		patch(0, 0),                     // zero rate
+		patch(16, 0),                    // zero growth
+		patch(24, 0),                    // no filter
+		patch(24, 1),                    // trailing filters
+		patch(scalableHeaderSize+8, 99), // first filter not full
+		patch(scalableHeaderSize+16, 1), // short filter
+	}
+
+	for i, d := range invalid {
+		if err := x.UnmarshalBinary(d); err != ErrInvalidFormat {
+			t.Fatalf("Case %d should be rejected, got %v", i, err)
+		}
+	}
+}
+
+func BenchmarkScalableAdd(b *testing.B) {
+	s := NewScalable(&Option{Items: 1000})
+	data := make([]byte, 8)
+
+	for i := 0; i < b.N; i++ {
+		binary.LittleEndian.PutUint64(data, uint64(i))
+		s.Add(data)
+	}
+}
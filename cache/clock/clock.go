@@ -0,0 +1,239 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package clock provides a CLOCK cache, an approximation of LRU whose
+// read path doesn't take any lock.
+//
+// Every element carries a reference bit. Get only sets this bit atomically,
+// while eviction sweeps a hand over the elements: a referenced element has
+// its bit cleared and gets a second chance, an unreferenced one is evicted.
+package clock
+
+import (
+	"container/list"
+	"github.com/flatpeach/coconut/cache"
+	"sync"
+	"sync/atomic"
+)
+
+var _ cache.Cache = (*Cache)(nil)
+
+type entry struct {
+	key  cache.Key
+	data atomic.Value // always holds a holder
+	ref  uint32       // reference bit, accessed atomically
+
+	size uint64        // guarded by Cache.mu
+	elem *list.Element // guarded by Cache.mu
+}
+
+// holder keeps the concrete type stored in atomic.Value consistent
+type holder struct {
+	data cache.Data
+}
+
+type Cache struct {
+	mu sync.Mutex // serializes writers, readers never take it
+
+	size   uint64
+	count  uint64
+	items  *list.List    // the clock, ordered by insertion
+	hand   *list.Element // next element to be examined by the eviction
+	caches sync.Map      // cache.Key -> *entry
+	o      *Option
+}
+
+func New(o *Option) *Cache {
+	c := &Cache{
+		size:  0,
+		count: 0,
+		items: list.New(),
+	}
+
+	if o == nil {
+		c.o = &Option{0, 0}
+	} else {
+		c.o = &Option{o.Capacity, o.MaxElements} // copy by value
+	}
+
+	return c
+}
+
+func (c *Cache) Set(key cache.Key, data cache.Data) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if v, ok := c.caches.Load(key); ok {
+		e := v.(*entry)
+		e.data.Store(holder{data})
+		atomic.StoreUint32(&e.ref, 1)
+
+		c.size -= e.size
+		e.size = data.Size()
+		c.size += e.size
+		c.checkCapacity()
+		return
+	}
+
+	e := &entry{
+		key:  key,
+		size: data.Size(),
+	}
+	e.data.Store(holder{data})
+
+	// New element is placed right behind the hand, so it will be the last
+	// one examined by the next sweep
+	if c.hand == nil {
+		e.elem = c.items.PushBack(e)
+	} else {
+		e.elem = c.items.InsertBefore(e, c.hand)
+	}
+
+	c.caches.Store(key, e)
+	c.count++
+
+	c.size += e.size
+	c.checkCapacity()
+}
+
+// Get never blocks on writers, it only marks the element as referenced
+func (c *Cache) Get(key cache.Key) (cache.Data, bool) {
+	v, ok := c.caches.Load(key)
+	if !ok {
+		return nil, false
+	}
+
+	e := v.(*entry)
+
+	// Avoid bouncing the cache line when the bit is already set
+	if atomic.LoadUint32(&e.ref) == 0 {
+		atomic.StoreUint32(&e.ref, 1)
+	}
+
+	return e.data.Load().(holder).data, true
+}
+
+func (c *Cache) Delete(key cache.Key) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if v, ok := c.caches.Load(key); ok {
+		c.removeElement(v.(*entry))
+	}
+}
+
+func (c *Cache) Size() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.size
+}
+
+func (c *Cache) ElementsCount() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.count
+}
+
+func (c *Cache) Capacity() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.o.Capacity
+}
+
+func (c *Cache) SetCapacity(capacity uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.o.Capacity = capacity
+	c.checkCapacity()
+}
+
+func (c *Cache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for e := c.items.Front(); e != nil; e = e.Next() {
+		c.caches.Delete(e.Value.(*entry).key)
+	}
+
+	c.items.Init()
+	c.hand = nil
+	c.size = 0
+	c.count = 0
+}
+
+func (c *Cache) Evict(n int) {
+	if n <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.evictElement(n)
+
+}
+
+func (c *Cache) Full() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.o.Capacity == 0 && c.o.MaxElements == 0 {
+		return false
+	}
+
+	if (c.o.Capacity != 0 && c.size >= c.o.Capacity) ||
+		(c.o.MaxElements != 0 && c.count >= c.o.MaxElements) {
+		return true
+	}
+
+	return false
+
+}
+
+func (c *Cache) checkCapacity() {
+	if c.o.Capacity == 0 && c.o.MaxElements == 0 {
+		return
+	}
+
+	for (c.o.Capacity != 0 && c.size > c.o.Capacity) ||
+		(c.o.MaxElements != 0 && c.count > c.o.MaxElements) {
+		c.evictElement(1)
+	}
+}
+
+func (c *Cache) removeElement(e *entry) {
+	if c.hand == e.elem {
+		c.hand = e.elem.Next()
+	}
+
+	c.items.Remove(e.elem)
+	c.caches.Delete(e.key)
+
+	c.size -= e.size
+	c.count--
+}
+
+// evictElement sweeps the hand until n unreferenced elements are evicted.
+// Every element is examined at most twice for one eviction, since the first
+// pass clears all the reference bits it meets.
+func (c *Cache) evictElement(n int) {
+	for n > 0 && c.count > 0 {
+		if c.hand == nil {
+			c.hand = c.items.Front()
+		}
+
+		e := c.hand.Value.(*entry)
+
+		if atomic.CompareAndSwapUint32(&e.ref, 1, 0) {
+			c.hand = c.hand.Next()
+			continue
+		}
+
+		c.removeElement(e)
+		n--
+	}
+}
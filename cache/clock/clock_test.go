@@ -0,0 +1,199 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package clock
+
+import (
+	"github.com/flatpeach/coconut/cache"
+	"github.com/flatpeach/coconut/cache/lru"
+	"sync"
+	"testing"
+)
+
+type cacheItem struct {
+	v []byte
+}
+
+func (i *cacheItem) Size() uint64 {
+	return uint64(len(i.v))
+}
+
+func TestClockBasic(t *testing.T) {
+	c := New(&Option{1 << 20, 0})
+
+	if c.Capacity() != 1<<20 {
+		t.Fatal("The capacity of CLOCK cache not matched!")
+	}
+
+	key := "hello"
+	value := &cacheItem{[]byte("HelloWorld")}
+
+	c.Set(key, value)
+
+	v, ok := c.Get(key)
+	if !ok {
+		t.Fatal("Didn't get the key in memory")
+	}
+
+	if v.(*cacheItem) != value {
+		t.Fatal("Data mismatched!")
+	}
+
+	if c.Size() != value.Size() {
+		t.Fatal("size not matched")
+	}
+
+	other := &cacheItem{[]byte("Hello")}
+	c.Set(key, other)
+
+	if v, _ := c.Get(key); v.(*cacheItem) != other {
+		t.Fatal("Data should be replaced")
+	}
+
+	if c.Size() != other.Size() || c.ElementsCount() != 1 {
+		t.Fatal("Replacing data should not leak size")
+	}
+
+	c.Delete(key)
+
+	if c.Size() != 0 || c.ElementsCount() != 0 {
+		t.Fatal("Failed to delete one item")
+	}
+
+	if _, ok = c.Get(key); ok {
+		t.Fatal("Failed to delete one element, still can access")
+	}
+
+}
+
+func TestClockSecondChance(t *testing.T) {
+	c := New(&Option{0, 3})
+
+	v := &cacheItem{[]byte("a")}
+
+	c.Set("k1", v)
+	c.Set("k2", v)
+	c.Set("k3", v)
+
+	c.Get("k1")
+
+	c.Set("k4", v)
+
+	if _, ok := c.Get("k1"); !ok {
+		t.Fatal("k1 was referenced and should get a second chance")
+	}
+
+	if _, ok := c.Get("k2"); ok {
+		t.Fatal("k2 should be evicted")
+	}
+
+	c.Set("k5", v)
+
+	if _, ok := c.Get("k3"); ok {
+		t.Fatal("k3 should be evicted")
+	}
+
+	if c.ElementsCount() != 3 || !c.Full() {
+		t.Fatal("Count of elements should be equal to 3")
+	}
+
+	c.Evict(3)
+	if c.ElementsCount() != 0 {
+		t.Fatal("Count of elements should be equal to 0")
+	}
+
+	c.Set("k6", v)
+	c.Clear()
+
+	if c.ElementsCount() != 0 || c.Size() != 0 {
+		t.Fatal("Should be a empty cache")
+	}
+
+	if _, ok := c.Get("k6"); ok {
+		t.Fatal("Clear should remove every key")
+	}
+
+}
+
+func TestClockConcurrent(t *testing.T) {
+	c := New(&Option{0, 64})
+
+	v := &cacheItem{[]byte("a")}
+
+	var wg sync.WaitGroup
+
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+
+			for i := 0; i < 1000; i++ {
+				if i%4 == 0 {
+					c.Set(g*1000+i, v)
+				} else {
+					c.Get(g*1000 + i - i%4)
+				}
+			}
+		}(g)
+	}
+
+	wg.Wait()
+
+	if c.ElementsCount() != 64 {
+		t.Fatal("Count of elements should be bounded by MaxElements")
+	}
+}
+
+const benchKeys = 1024
+
+func benchmarkGet(b *testing.B, c cache.Cache, goroutines int) {
+	v := &cacheItem{[]byte("a")}
+
+	for i := 0; i < benchKeys; i++ {
+		c.Set(i, v)
+	}
+
+	n := b.N/goroutines + 1
+
+	var wg sync.WaitGroup
+
+	b.ResetTimer()
+
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+
+			for i := 0; i < n; i++ {
+				c.Get((g + i) % benchKeys)
+			}
+		}(g)
+	}
+
+	wg.Wait()
+}
+
+func BenchmarkClockGet1(b *testing.B) {
+	benchmarkGet(b, New(nil), 1)
+}
+
+func BenchmarkClockGet8(b *testing.B) {
+	benchmarkGet(b, New(nil), 8)
+}
+
+func BenchmarkClockGet64(b *testing.B) {
+	benchmarkGet(b, New(nil), 64)
+}
+
+func BenchmarkLRUGet1(b *testing.B) {
+	benchmarkGet(b, lru.New(nil), 1)
+}
+
+func BenchmarkLRUGet8(b *testing.B) {
+	benchmarkGet(b, lru.New(nil), 8)
+}
+
+func BenchmarkLRUGet64(b *testing.B) {
+	benchmarkGet(b, lru.New(nil), 64)
+}
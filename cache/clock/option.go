@@ -0,0 +1,10 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package clock
+
+type Option struct {
+	Capacity    uint64
+	MaxElements uint64
+}
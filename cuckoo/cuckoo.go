@@ -0,0 +1,271 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package cuckoo provides the cuckoo filter, see "Cuckoo Filter: Practically
+// Better Than Bloom" by Fan, Andersen, Kaminsky and Mitzenmacher.
+//
+// Only a small fingerprint of each item is stored, in one of its two
+// candidate buckets. The second bucket is derived from the first one and
+// the fingerprint, so fingerprints can be moved around without the items.
+package cuckoo
+
+import (
+	"errors"
+	"github.com/flatpeach/coconut/hash/murmur3"
+	"sync"
+)
+
+var (
+	ErrFull          = errors.New("cuckoo: filter is full")
+	ErrInvalidFormat = errors.New("cuckoo: invalid serialized filter")
+)
+
+// Buckets are filled up to this load when sizing the filter
+const loadFactor = 0.95
+
+type Filter struct {
+	mu sync.Mutex
+
+	bits       uint   // bits of one fingerprint
+	mask       uint64 // fingerprint mask
+	bucketSize uint64 // fingerprints per bucket
+	buckets    uint64 // count of buckets, a power of two
+	maxKicks   uint
+
+	count uint64   // items inserted
+	slots []uint64 // packed fingerprints, zero is an empty slot
+	rand  uint64   // state of the generator picking the victims
+}
+
+// kick is one fingerprint moved by an insertion
+type kick struct {
+	bucket uint64
+	slot   uint64
+}
+
+// Return back a new Filter sized according the option passed in
+func New(option *Option) *Filter {
+	if option == nil {
+		option = &Option{}
+	}
+
+	option = option.normalize()
+
+	need := uint64(float64(option.Items)/loadFactor)/uint64(option.BucketSize) + 1
+
+	buckets := uint64(1)
+	for buckets < need {
+		buckets <<= 1
+	}
+
+	return newFilter(option.FingerprintBits, uint64(option.BucketSize), buckets, option.MaxKicks)
+}
+
+func newFilter(bits uint, bucketSize, buckets uint64, maxKicks uint) *Filter {
+	return &Filter{
+		bits:       bits,
+		mask:       1<<bits - 1,
+		bucketSize: bucketSize,
+		buckets:    buckets,
+		maxKicks:   maxKicks,
+		slots:      make([]uint64, slotWords(bits, bucketSize*buckets)),
+		rand:       1,
+	}
+}
+
+// slotWords returns the words holding n fingerprints, plus one so that
+// reading a fingerprint never goes past the end
+func slotWords(bits uint, n uint64) uint64 {
+	return n*uint64(bits)/64 + 1
+}
+
+// get returns the fingerprint at slot j of the whole table
+func (f *Filter) get(j uint64) uint64 {
+	off := j * uint64(f.bits)
+	w, s := off/64, uint(off%64)
+
+	v := f.slots[w] >> s
+	if s+f.bits > 64 {
+		v |= f.slots[w+1] << (64 - s)
+	}
+
+	return v & f.mask
+}
+
+func (f *Filter) set(j, v uint64) {
+	off := j * uint64(f.bits)
+	w, s := off/64, uint(off%64)
+
+	f.slots[w] = f.slots[w]&^(f.mask<<s) | v<<s
+	if s+f.bits > 64 {
+		f.slots[w+1] = f.slots[w+1]&^(f.mask>>(64-s)) | v>>(64-s)
+	}
+}
+
+// next is a xorshift generator, the same filter kicks the same way
+func (f *Filter) next() uint64 {
+	f.rand ^= f.rand << 13
+	f.rand ^= f.rand >> 7
+	f.rand ^= f.rand << 17
+
+	return f.rand
+}
+
+// locate returns the fingerprint and the first bucket of data
+func (f *Filter) locate(data []byte) (uint64, uint64) {
+	h1, h2 := murmur3.Sum128(data, 0)
+
+	fp := h2 & f.mask
+	if fp == 0 {
+		fp = 1
+	}
+
+	return fp, h1 & (f.buckets - 1)
+}
+
+// alt returns the other bucket of fingerprint fp stored in bucket i
+func (f *Filter) alt(i, fp uint64) uint64 {
+	return (i ^ fp*0x5bd1e995) & (f.buckets - 1)
+}
+
+// find returns the slot of bucket i holding fp
+func (f *Filter) find(i, fp uint64) (uint64, bool) {
+	for j := i * f.bucketSize; j < (i+1)*f.bucketSize; j++ {
+		if f.get(j) == fp {
+			return j, true
+		}
+	}
+
+	return 0, false
+}
+
+// Insert one item, ErrFull is returned and the filter is left untouched if
+// no room can be made for it
+func (f *Filter) Insert(data []byte) error {
+	fp, i := f.locate(data)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, b := range []uint64{i, f.alt(i, fp)} {
+		if j, ok := f.find(b, 0); ok {
+			f.set(j, fp)
+			f.count++
+			return nil
+		}
+	}
+
+	// Kick fingerprints to their other bucket until one finds an empty slot
+	if f.next()&1 == 1 {
+		i = f.alt(i, fp)
+	}
+
+	path := make([]kick, 0, f.maxKicks)
+
+	for n := uint(0); n < f.maxKicks; n++ {
+		j := i*f.bucketSize + f.next()%f.bucketSize
+		path = append(path, kick{i, j})
+
+		victim := f.get(j)
+		f.set(j, fp)
+		fp = victim
+
+		i = f.alt(i, fp)
+		if j, ok := f.find(i, 0); ok {
+			f.set(j, fp)
+			f.count++
+			return nil
+		}
+	}
+
+	// Undo the kicks in reverse order
+	for n := len(path) - 1; n >= 0; n-- {
+		j := path[n].slot
+
+		victim := f.get(j)
+		f.set(j, fp)
+		fp = victim
+	}
+
+	return ErrFull
+}
+
+// Lookup whether one item is probably in the filter
+func (f *Filter) Lookup(data []byte) bool {
+	fp, i := f.locate(data)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.find(i, fp); ok {
+		return true
+	}
+
+	_, ok := f.find(f.alt(i, fp), fp)
+	return ok
+}
+
+// Delete one item, reports whether it was found. Deleting an item never
+// inserted may delete another item sharing its fingerprint.
+func (f *Filter) Delete(data []byte) bool {
+	fp, i := f.locate(data)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, b := range []uint64{i, f.alt(i, fp)} {
+		if j, ok := f.find(b, fp); ok {
+			f.set(j, 0)
+			f.count--
+			return true
+		}
+	}
+
+	return false
+}
+
+// InsertString inserts one string
+func (f *Filter) InsertString(s string) error {
+	return f.Insert([]byte(s))
+}
+
+// LookupString looks one string up
+func (f *Filter) LookupString(s string) bool {
+	return f.Lookup([]byte(s))
+}
+
+// DeleteString deletes one string
+func (f *Filter) DeleteString(s string) bool {
+	return f.Delete([]byte(s))
+}
+
+// Count returns how many items are in the filter
+func (f *Filter) Count() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.count
+}
+
+// Cap returns how many fingerprints the filter can hold
+func (f *Filter) Cap() uint64 {
+	return f.buckets * f.bucketSize
+}
+
+// LoadFactor returns the ratio of used slots
+func (f *Filter) LoadFactor() float64 {
+	return float64(f.Count()) / float64(f.Cap())
+}
+
+// Reinit the whole filter
+func (f *Filter) ClearAll() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i := range f.slots {
+		f.slots[i] = 0
+	}
+
+	f.count = 0
+}
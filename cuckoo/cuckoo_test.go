@@ -0,0 +1,230 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cuckoo
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func key(i int) string {
+	return "key-" + strconv.Itoa(i)
+}
+
+func TestFilterSlots(t *testing.T) {
+	for _, bits := range []uint{1, 4, 7, 12, 16, 31, 32} {
+		f := newFilter(bits, 4, 64, 500)
+		model := make([]uint64, f.Cap())
+
+		r := rand.New(rand.NewSource(int64(bits)))
+		for n := 0; n < 10000; n++ {
+			j := uint64(r.Intn(len(model)))
+			v := r.Uint64() & f.mask
+
+			f.set(j, v)
+			model[j] = v
+		}
+
+		for j, v := range model {
+			if f.get(uint64(j)) != v {
+				t.Fatalf("Slot %d of %d bits should be %d, got %d", j, bits, v, f.get(uint64(j)))
+			}
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	const n = 100000
+
+	f := New(&Option{Items: n})
+
+	for i := 0; i < n; i++ {
+		if err := f.InsertString(key(i)); err != nil {
+			t.Fatalf("Insert %d: %v", i, err)
+		}
+	}
+
+	if f.Count() != n {
+		t.Fatalf("Count should be %d, got %d", n, f.Count())
+	}
+
+	for i := 0; i < n; i++ {
+		if !f.LookupString(key(i)) {
+			t.Fatalf("%s should be in the filter", key(i))
+		}
+	}
+
+	// 2*4/2^16 is about 0.00012
+	fp := 0
+	for i := n; i < 11*n; i++ {
+		if f.LookupString(key(i)) {
+			fp++
+		}
+	}
+
+	if r := float64(fp) / float64(10*n); r > 0.0002 {
+		t.Fatalf("False positive rate %f is too high", r)
+	}
+
+	for i := 0; i < n; i += 2 {
+		if !f.DeleteString(key(i)) {
+			t.Fatalf("%s should be deleted", key(i))
+		}
+	}
+
+	for i := 1; i < n; i += 2 {
+		if !f.LookupString(key(i)) {
+			t.Fatalf("%s should stay in the filter", key(i))
+		}
+	}
+
+	if f.Count() != n/2 {
+		t.Fatalf("Count should be %d, got %d", n/2, f.Count())
+	}
+
+	if f.DeleteString("absent") {
+		t.Fatal("absent was never inserted")
+	}
+
+	f.ClearAll()
+	if f.Count() != 0 || f.LookupString(key(1)) {
+		t.Fatal("Filter should be empty")
+	}
+}
+
+func TestFilterFull(t *testing.T) {
+	for _, bits := range []uint{8, 12, 16} {
+		f := New(&Option{Items: 1000, FingerprintBits: bits, BucketSize: 2, MaxKicks: 100})
+
+		inserted := 0
+		for i := 0; ; i++ {
+			if err := f.InsertString(key(i)); err != nil {
+				if err != ErrFull {
+					t.Fatal(err)
+				}
+
+				break
+			}
+
+			inserted++
+		}
+
+		if f.LoadFactor() < 0.7 {
+			t.Fatalf("Filter of %d bits is full too early: %f", bits, f.LoadFactor())
+		}
+
+		// A failed insertion leaves every item in place
+		if f.Count() != uint64(inserted) {
+			t.Fatalf("Count should be %d, got %d", inserted, f.Count())
+		}
+
+		for i := 0; i < inserted; i++ {
+			if !f.LookupString(key(i)) {
+				t.Fatalf("%s should be in the filter", key(i))
+			}
+		}
+	}
+}
+
+func TestFilterDuplicates(t *testing.T) {
+	f := New(&Option{Items: 100, BucketSize: 4})
+
+	// The same item fills its two buckets
+	for i := 0; i < 8; i++ {
+		if err := f.InsertString("x"); err != nil {
+			t.Fatalf("Insert %d: %v", i, err)
+		}
+	}
+
+	if f.InsertString("x") != ErrFull {
+		t.Fatal("Both buckets of x should be full")
+	}
+
+	for i := 0; i < 8; i++ {
+		if !f.DeleteString("x") {
+			t.Fatalf("Delete %d should find x", i)
+		}
+	}
+
+	if f.LookupString("x") || f.Count() != 0 {
+		t.Fatal("x should be gone")
+	}
+}
+
+func TestFilterSerialize(t *testing.T) {
+	f := New(&Option{Items: 1000, FingerprintBits: 12, BucketSize: 3})
+	for i := 0; i < 1000; i++ {
+		f.InsertString(key(i))
+	}
+
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := New(nil)
+	if err := g.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if g.Count() != f.Count() || g.Cap() != f.Cap() {
+		t.Fatal("Filter should survive serialization")
+	}
+
+	for i := 0; i < 2000; i++ {
+		if f.LookupString(key(i)) != g.LookupString(key(i)) {
+			t.Fatalf("%s should look up the same", key(i))
+		}
+	}
+
+	patch := func(off int, v uint64) []byte {
+		d := append([]byte(nil), data...)
+		binary.LittleEndian.PutUint64(d[off:], v)
+		return d
+	}
+
+	last := len(data) - 8
+	invalid := [][]byte{
+		nil,
+		data[:headerSize],
+		data[:len(data)-1],
+		append(append([]byte(nil), data...), 0),
+		patch(0, 0),            // no fingerprint bits
+		patch(0, 33),           // too wide fingerprints
+		patch(8, 0),            // empty buckets
+		patch(16, 3),           // not a power of two
+		patch(16, 1<<62),       // too many buckets
+		patch(24, 0),           // no kick
+		patch(32, f.Count()+1), // wrong count
+		patch(last, 1<<63),     // past the last slot
+	}
+
+	for i, d := range invalid {
+		if err := g.UnmarshalBinary(d); err != ErrInvalidFormat {
+			t.Fatalf("Case %d should be rejected, got %v", i, err)
+		}
+	}
+}
+
+func BenchmarkFilterInsert(b *testing.B) {
+	f := New(&Option{Items: uint64(b.N)})
+	data := make([]byte, 8)
+
+	for i := 0; i < b.N; i++ {
+		binary.LittleEndian.PutUint64(data, uint64(i))
+		f.Insert(data)
+	}
+}
+
+func BenchmarkFilterLookup(b *testing.B) {
+	f := New(&Option{Items: 1000})
+	data := []byte("0123456789abcdef")
+
+	for i := 0; i < b.N; i++ {
+		f.Lookup(data)
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cuckoo
+
+// Option used to construct the Filter
+type Option struct {
+	// Expected count of items to be inserted, zero is treated as one
+	Items uint64
+
+	// Bits of one fingerprint, from 1 to 32, zero means 16. The false
+	// positive rate is about 2*BucketSize/2^FingerprintBits.
+	FingerprintBits uint
+
+	// Fingerprints held by one bucket, zero means 4
+	BucketSize uint
+
+	// How many fingerprints are kicked out at most by one insertion before
+	// the filter is reported full, zero means 500
+	MaxKicks uint
+}
+
+func (o *Option) clone() *Option {
+	return &Option{
+		Items:           o.Items,
+		FingerprintBits: o.FingerprintBits,
+		BucketSize:      o.BucketSize,
+		MaxKicks:        o.MaxKicks,
+	}
+}
+
+// normalize fixes the zero values and out of range fields
+func (o *Option) normalize() *Option {
+	o = o.clone()
+
+	if o.Items == 0 {
+		o.Items = 1
+	}
+
+	if o.FingerprintBits == 0 {
+		o.FingerprintBits = 16
+	}
+
+	if o.FingerprintBits > 32 {
+		o.FingerprintBits = 32
+	}
+
+	if o.BucketSize == 0 {
+		o.BucketSize = 4
+	}
+
+	if o.MaxKicks == 0 {
+		o.MaxKicks = 500
+	}
+
+	return o
+}
@@ -0,0 +1,92 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cuckoo
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// Filters are serialized as the fingerprint bits, the bucket size, the
+// count of buckets, the max kicks and the count of items, followed by the
+// words of the packed fingerprints. All numbers are little endian uint64s.
+const headerSize = 40
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface
+func (f *Filter) MarshalBinary() ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data := make([]byte, headerSize+8*len(f.slots))
+
+	binary.LittleEndian.PutUint64(data, uint64(f.bits))
+	binary.LittleEndian.PutUint64(data[8:], f.bucketSize)
+	binary.LittleEndian.PutUint64(data[16:], f.buckets)
+	binary.LittleEndian.PutUint64(data[24:], uint64(f.maxKicks))
+	binary.LittleEndian.PutUint64(data[32:], f.count)
+
+	for i, w := range f.slots {
+		binary.LittleEndian.PutUint64(data[headerSize+8*i:], w)
+	}
+
+	return data, nil
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface
+func (f *Filter) UnmarshalBinary(data []byte) error {
+	if len(data) < headerSize {
+		return ErrInvalidFormat
+	}
+
+	fpBits := binary.LittleEndian.Uint64(data)
+	bucketSize := binary.LittleEndian.Uint64(data[8:])
+	buckets := binary.LittleEndian.Uint64(data[16:])
+	maxKicks := binary.LittleEndian.Uint64(data[24:])
+	count := binary.LittleEndian.Uint64(data[32:])
+
+	if fpBits == 0 || fpBits > 32 || bucketSize == 0 || maxKicks == 0 ||
+		uint64(uint(maxKicks)) != maxKicks || bits.OnesCount64(buckets) != 1 {
+		return ErrInvalidFormat
+	}
+
+	// The slots must fit the data before computing their words
+	words := uint64(len(data)-headerSize) / 8
+	if hi, n := bits.Mul64(bucketSize, buckets); hi != 0 || n > words*64/fpBits ||
+		slotWords(uint(fpBits), n) != words || (len(data)-headerSize)%8 != 0 {
+		return ErrInvalidFormat
+	}
+
+	x := newFilter(uint(fpBits), bucketSize, buckets, uint(maxKicks))
+
+	for i := range x.slots {
+		x.slots[i] = binary.LittleEndian.Uint64(data[headerSize+8*i:])
+	}
+
+	// Every used slot must be counted, and nothing set past the last slot
+	used := uint64(0)
+	for j := uint64(0); j < x.Cap(); j++ {
+		if x.get(j) != 0 {
+			used++
+		}
+	}
+
+	if used != count {
+		return ErrInvalidFormat
+	}
+
+	if rest := x.Cap() * uint64(x.bits) % 64; x.slots[len(x.slots)-1]>>rest != 0 {
+		return ErrInvalidFormat
+	}
+
+	x.count = count
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.bits, f.mask, f.bucketSize, f.buckets = x.bits, x.mask, x.bucketSize, x.buckets
+	f.maxKicks, f.count, f.slots = x.maxKicks, x.count, x.slots
+
+	return nil
+}
@@ -0,0 +1,155 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package murmur3 implements Austin Appleby's MurmurHash3, the x86 32 bits
+// and the x64 128 bits variants.
+package murmur3
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+const (
+	c1_32 uint32 = 0xcc9e2d51
+	c2_32 uint32 = 0x1b873593
+
+	c1_128 uint64 = 0x87c37b91114253d5
+	c2_128 uint64 = 0x4cf5ad432745937f
+)
+
+// Sum32 returns the x86 32 bits hash of data
+func Sum32(data []byte, seed uint32) uint32 {
+	h := seed
+	n := len(data) / 4
+
+	for i := 0; i < n; i++ {
+		k := binary.LittleEndian.Uint32(data[i*4:])
+
+		k *= c1_32
+		k = bits.RotateLeft32(k, 15)
+		k *= c2_32
+
+		h ^= k
+		h = bits.RotateLeft32(h, 13)
+		h = h*5 + 0xe6546b64
+	}
+
+	tail := data[n*4:]
+	var k uint32
+
+	switch len(tail) {
+	case 3:
+		k ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(tail[0])
+		k *= c1_32
+		k = bits.RotateLeft32(k, 15)
+		k *= c2_32
+		h ^= k
+	}
+
+	h ^= uint32(len(data))
+
+	return fmix32(h)
+}
+
+// Sum128 returns the x64 128 bits hash of data as two halves
+func Sum128(data []byte, seed uint32) (uint64, uint64) {
+	h1, h2 := uint64(seed), uint64(seed)
+	n := len(data) / 16
+
+	for i := 0; i < n; i++ {
+		k1 := binary.LittleEndian.Uint64(data[i*16:])
+		k2 := binary.LittleEndian.Uint64(data[i*16+8:])
+
+		k1 *= c1_128
+		k1 = bits.RotateLeft64(k1, 31)
+		k1 *= c2_128
+		h1 ^= k1
+
+		h1 = bits.RotateLeft64(h1, 27)
+		h1 += h2
+		h1 = h1*5 + 0x52dce729
+
+		k2 *= c2_128
+		k2 = bits.RotateLeft64(k2, 33)
+		k2 *= c1_128
+		h2 ^= k2
+
+		h2 = bits.RotateLeft64(h2, 31)
+		h2 += h1
+		h2 = h2*5 + 0x38495ab5
+	}
+
+	tail := data[n*16:]
+	var k1, k2 uint64
+
+	// The tail is at most 15 bytes, the upper 8 go into k2
+	for i := 8; i < len(tail); i++ {
+		k2 ^= uint64(tail[i]) << (uint(i-8) * 8)
+	}
+
+	if len(tail) > 8 {
+		k2 *= c2_128
+		k2 = bits.RotateLeft64(k2, 33)
+		k2 *= c1_128
+		h2 ^= k2
+	}
+
+	for i := 0; i < len(tail) && i < 8; i++ {
+		k1 ^= uint64(tail[i]) << (uint(i) * 8)
+	}
+
+	if len(tail) > 0 {
+		k1 *= c1_128
+		k1 = bits.RotateLeft64(k1, 31)
+		k1 *= c2_128
+		h1 ^= k1
+	}
+
+	h1 ^= uint64(len(data))
+	h2 ^= uint64(len(data))
+
+	h1 += h2
+	h2 += h1
+
+	h1 = fmix64(h1)
+	h2 = fmix64(h2)
+
+	h1 += h2
+	h2 += h1
+
+	return h1, h2
+}
+
+// Sum64 returns the first half of the x64 128 bits hash of data
+func Sum64(data []byte, seed uint32) uint64 {
+	h1, _ := Sum128(data, seed)
+	return h1
+}
+
+func fmix32(h uint32) uint32 {
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+
+	return h
+}
+
+func fmix64(k uint64) uint64 {
+	k ^= k >> 33
+	k *= 0xff51afd7ed558ccd
+	k ^= k >> 33
+	k *= 0xc4ceb9fe1a85ec53
+	k ^= k >> 33
+
+	return k
+}
@@ -0,0 +1,81 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package murmur3
+
+import (
+	"testing"
+)
+
+var vectors = []struct {
+	data   string
+	seed   uint32
+	h32    uint32
+	h1, h2 uint64
+}{
+	{"", 0, 0, 0, 0},
+	{"hello", 0, 0x248bfa47, 0xcbd8a7b341bd9b02, 0x5b1e906a48ae1d19},
+	{"hello", 42, 0xe2dbd2e1, 0xc4b8b3c960af6f08, 0x2334b875b0efbc7a},
+	{"hello, world", 0, 0x149bbb7f, 0x342fac623a5ebc8e, 0x4cdcbc079642414d},
+	{"The quick brown fox jumps over the lazy dog.", 0, 0xd5c48bfc, 0xcd99481f9ee902c9, 0x695da1a38987b6e7},
+}
+
+func TestSum32(t *testing.T) {
+	for _, v := range vectors {
+		if h := Sum32([]byte(v.data), v.seed); h != v.h32 {
+			t.Fatalf("Sum32(%q, %d) should be %#x, got %#x", v.data, v.seed, v.h32, h)
+		}
+	}
+}
+
+func TestSum128(t *testing.T) {
+	for _, v := range vectors {
+		h1, h2 := Sum128([]byte(v.data), v.seed)
+		if h1 != v.h1 || h2 != v.h2 {
+			t.Fatalf("Sum128(%q, %d) should be %#x %#x, got %#x %#x", v.data, v.seed, v.h1, v.h2, h1, h2)
+		}
+
+		if h := Sum64([]byte(v.data), v.seed); h != v.h1 {
+			t.Fatalf("Sum64(%q, %d) should be %#x, got %#x", v.data, v.seed, v.h1, h)
+		}
+	}
+}
+
+func TestSumTails(t *testing.T) {
+	// Every tail length must reach the hash, flipping any byte changes it
+	data := []byte("0123456789abcdefghijklmnopqrstuv")
+
+	for n := 1; n <= len(data); n++ {
+		h1, h2 := Sum128(data[:n], 0)
+		h32 := Sum32(data[:n], 0)
+
+		for i := 0; i < n; i++ {
+			b := append([]byte(nil), data[:n]...)
+			b[i] ^= 1
+
+			x1, x2 := Sum128(b, 0)
+			if x1 == h1 || x2 == h2 || Sum32(b, 0) == h32 {
+				t.Fatalf("Flipping byte %d of %d bytes should change the hash", i, n)
+			}
+		}
+	}
+}
+
+func BenchmarkSum32(b *testing.B) {
+	data := make([]byte, 64)
+	b.SetBytes(int64(len(data)))
+
+	for i := 0; i < b.N; i++ {
+		Sum32(data, 0)
+	}
+}
+
+func BenchmarkSum128(b *testing.B) {
+	data := make([]byte, 64)
+	b.SetBytes(int64(len(data)))
+
+	for i := 0; i < b.N; i++ {
+		Sum128(data, 0)
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package scheduling defines the interfaces shared by the strategies
+// spreading requests over nodes, which live in the sub packages.
+package scheduling
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrNoNode = errors.New("scheduling: no node available")
+
+// Nodes are compared with ==, so they must be comparable
+type Node interface {
+	Weight() int
+}
+
+// NamedNode is a node identified by its name, for the strategies mapping
+// keys to nodes
+type NamedNode interface {
+	Node
+
+	// Name of the node, unique among the nodes
+	Name() string
+}
+
+// Balancer picks the node serving each request
+type Balancer interface {
+	// Pick one node for a new request, ErrNoNode is returned if there is
+	// no node of positive weight
+	Pick() (Node, error)
+
+	// Done reports the request sent to node completed after latency, err
+	// is nil if it succeeded. Every Pick must be followed by one Done.
+	Done(node Node, latency time.Duration, err error)
+}
@@ -0,0 +1,217 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package consistent provides a consistent hash ring, see "Consistent
+// Hashing and Random Trees" by Karger et al.
+//
+// Every node is hashed into Replicas*Weight virtual nodes on the ring, and
+// a key belongs to the first virtual node found clockwise from its hash.
+// Adding or removing a node only moves the keys of its virtual nodes.
+package consistent
+
+import (
+	"github.com/flatpeach/coconut/hash/murmur3"
+	"github.com/flatpeach/coconut/scheduling"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const DefaultReplicas = 100
+
+type Ring struct {
+	mu sync.RWMutex
+
+	replicas int // virtual nodes per unit of weight
+	points   []point
+	nodes    map[string]scheduling.NamedNode
+}
+
+// point is one virtual node
+type point struct {
+	hash uint64
+	node scheduling.NamedNode
+}
+
+// Return back a new Ring with replicas virtual nodes per unit of weight,
+// zero or less means DefaultReplicas
+func New(replicas int, nodes ...scheduling.NamedNode) *Ring {
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+
+	r := &Ring{
+		replicas: replicas,
+		nodes:    make(map[string]scheduling.NamedNode),
+	}
+
+	for _, node := range nodes {
+		r.add(node)
+	}
+
+	r.sort()
+
+	return r
+}
+
+// add puts the virtual nodes of node in place of the ones of the node
+// having the same name, the ring has to be sorted then
+func (r *Ring) add(node scheduling.NamedNode) {
+	if _, ok := r.nodes[node.Name()]; ok {
+		r.drop(node.Name())
+	}
+
+	r.nodes[node.Name()] = node
+	r.points = append(r.points, r.pointsOf(node)...)
+}
+
+func hash(key string) uint64 {
+	return murmur3.Sum64([]byte(key), 0)
+}
+
+// pointsOf returns the virtual nodes of node, none for a zero weight
+func (r *Ring) pointsOf(node scheduling.NamedNode) []point {
+	n := node.Weight() * r.replicas
+	if n <= 0 {
+		return nil
+	}
+
+	points := make([]point, n)
+	for i := range points {
+		points[i] = point{hash(node.Name() + "#" + strconv.Itoa(i)), node}
+	}
+
+	return points
+}
+
+// sort orders the ring, colliding virtual nodes by name so every ring with
+// the same nodes is the same
+func (r *Ring) sort() {
+	sort.Slice(r.points, func(i, j int) bool {
+		if r.points[i].hash != r.points[j].hash {
+			return r.points[i].hash < r.points[j].hash
+		}
+
+		return r.points[i].node.Name() < r.points[j].node.Name()
+	})
+}
+
+// drop removes the virtual nodes of the node named name
+func (r *Ring) drop(name string) {
+	points := r.points[:0]
+
+	for _, p := range r.points {
+		if p.node.Name() != name {
+			points = append(points, p)
+		}
+	}
+
+	for i := len(points); i < len(r.points); i++ {
+		r.points[i] = point{}
+	}
+
+	r.points = points
+}
+
+// search returns the position of the first virtual node of key
+func (r *Ring) search(key string) int {
+	h := hash(key)
+
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i].hash >= h
+	})
+
+	if i == len(r.points) {
+		i = 0
+	}
+
+	return i
+}
+
+// Get returns the node key belongs to
+func (r *Ring) Get(key string) (scheduling.NamedNode, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.points) == 0 {
+		return nil, scheduling.ErrNoNode
+	}
+
+	return r.points[r.search(key)].node, nil
+}
+
+// GetN returns up to n distinct nodes for the replicas of key, walking
+// clockwise from it. The first one is the node returned by Get.
+func (r *Ring) GetN(key string, n int) ([]scheduling.NamedNode, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.points) == 0 {
+		return nil, scheduling.ErrNoNode
+	}
+
+	var nodes []scheduling.NamedNode
+	seen := make(map[string]bool)
+
+	start := r.search(key)
+	for i := 0; i < len(r.points) && len(nodes) < n; i++ {
+		node := r.points[(start+i)%len(r.points)].node
+
+		if !seen[node.Name()] {
+			seen[node.Name()] = true
+			nodes = append(nodes, node)
+		}
+	}
+
+	return nodes, nil
+}
+
+// Add one node, a node of the same name is replaced
+func (r *Ring) Add(node scheduling.NamedNode) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.add(node)
+	r.sort()
+}
+
+// Remove one node, reports whether it was found
+func (r *Ring) Remove(node scheduling.NamedNode) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.nodes[node.Name()]; !ok {
+		return false
+	}
+
+	delete(r.nodes, node.Name())
+	r.drop(node.Name())
+
+	return true
+}
+
+// Update tells the weight of one node changed, reports whether it was
+// found. Virtual nodes are numbered, so only the keys of the virtual nodes
+// added or removed move.
+func (r *Ring) Update(node scheduling.NamedNode) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.nodes[node.Name()]; !ok {
+		return false
+	}
+
+	r.add(node)
+	r.sort()
+
+	return true
+}
+
+// Len returns how many nodes are on the ring
+func (r *Ring) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return len(r.nodes)
+}
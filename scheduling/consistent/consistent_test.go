@@ -0,0 +1,238 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package consistent
+
+import (
+	"github.com/flatpeach/coconut/scheduling"
+	"strconv"
+	"testing"
+)
+
+type node struct {
+	name   string
+	weight int
+}
+
+func (n *node) Weight() int {
+	return n.weight
+}
+
+func (n *node) Name() string {
+	return n.name
+}
+
+const keys = 100000
+
+func key(i int) string {
+	return "key-" + strconv.Itoa(i)
+}
+
+func newNodes(n int) []scheduling.NamedNode {
+	var nodes []scheduling.NamedNode
+	for i := 0; i < n; i++ {
+		nodes = append(nodes, &node{"node-" + strconv.Itoa(i), 1})
+	}
+
+	return nodes
+}
+
+// assign maps every key to its node name
+func assign(t *testing.T, r *Ring) []string {
+	names := make([]string, keys)
+
+	for i := range names {
+		n, err := r.Get(key(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		names[i] = n.Name()
+	}
+
+	return names
+}
+
+func TestRingBalance(t *testing.T) {
+	r := New(200, newNodes(10)...)
+
+	counts := map[string]int{}
+	for _, name := range assign(t, r) {
+		counts[name]++
+	}
+
+	for name, c := range counts {
+		if c < keys/10*80/100 || c > keys/10*120/100 {
+			t.Fatalf("%s should own about %d keys, got %d", name, keys/10, c)
+		}
+	}
+}
+
+func TestRingWeighted(t *testing.T) {
+	light, heavy := &node{"light", 1}, &node{"heavy", 3}
+	r := New(200, light, heavy)
+
+	counts := map[string]int{}
+	for _, name := range assign(t, r) {
+		counts[name]++
+	}
+
+	if c := counts["heavy"]; c < keys*70/100 || c > keys*80/100 {
+		t.Fatalf("heavy should own about 75%% of the keys, got %d", c)
+	}
+}
+
+func TestRingAdd(t *testing.T) {
+	r := New(200, newNodes(10)...)
+	before := assign(t, r)
+
+	r.Add(&node{"new", 1})
+	after := assign(t, r)
+
+	moved := 0
+	for i := range before {
+		if before[i] == after[i] {
+			continue
+		}
+
+		// Keys only move to the new node
+		if after[i] != "new" {
+			t.Fatalf("%s moved from %s to %s", key(i), before[i], after[i])
+		}
+
+		moved++
+	}
+
+	// About 1/11 of the keys move
+	if moved < keys/11*80/100 || moved > keys/11*120/100 {
+		t.Fatalf("About %d keys should move, got %d", keys/11, moved)
+	}
+}
+
+func TestRingRemove(t *testing.T) {
+	nodes := newNodes(10)
+	r := New(200, nodes...)
+	before := assign(t, r)
+
+	if !r.Remove(nodes[3]) || r.Remove(nodes[3]) {
+		t.Fatal("Node should be removed once")
+	}
+
+	after := assign(t, r)
+
+	moved := 0
+	for i := range before {
+		if before[i] == nodes[3].Name() {
+			moved++
+			continue
+		}
+
+		// Keys of the other nodes stay
+		if before[i] != after[i] {
+			t.Fatalf("%s moved from %s to %s", key(i), before[i], after[i])
+		}
+	}
+
+	if moved < keys/10*80/100 || moved > keys/10*120/100 {
+		t.Fatalf("About %d keys should move, got %d", keys/10, moved)
+	}
+
+	// Adding it back restores the same ring
+	r.Add(nodes[3])
+	again := assign(t, r)
+
+	for i := range before {
+		if before[i] != again[i] {
+			t.Fatalf("%s should be back on %s", key(i), before[i])
+		}
+	}
+}
+
+func TestRingUpdate(t *testing.T) {
+	nodes := newNodes(4)
+	r := New(100, nodes...)
+	before := assign(t, r)
+
+	nodes[0].(*node).weight = 2
+	if !r.Update(nodes[0]) || r.Update(&node{"absent", 1}) {
+		t.Fatal("Only known nodes should be updated")
+	}
+
+	after := assign(t, r)
+
+	gained := 0
+	for i := range before {
+		if before[i] == after[i] {
+			continue
+		}
+
+		if after[i] != nodes[0].Name() {
+			t.Fatalf("%s moved from %s to %s", key(i), before[i], after[i])
+		}
+
+		gained++
+	}
+
+	// From 1/4 to 2/5 of the keys
+	if gained < keys*10/100 || gained > keys*20/100 {
+		t.Fatalf("About 15%% of the keys should move, got %d", gained)
+	}
+}
+
+func TestRingGetN(t *testing.T) {
+	r := New(100, newNodes(5)...)
+
+	for i := 0; i < 1000; i++ {
+		first, _ := r.Get(key(i))
+
+		nodes, err := r.GetN(key(i), 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(nodes) != 3 || nodes[0] != first {
+			t.Fatalf("Should get 3 nodes starting with %s", first.Name())
+		}
+
+		if nodes[0] == nodes[1] || nodes[1] == nodes[2] || nodes[0] == nodes[2] {
+			t.Fatal("Nodes should be distinct")
+		}
+	}
+
+	// No more than the nodes on the ring
+	if nodes, _ := r.GetN("x", 10); len(nodes) != 5 {
+		t.Fatalf("Should get 5 nodes, got %d", len(nodes))
+	}
+}
+
+func TestRingEmpty(t *testing.T) {
+	r := New(0)
+
+	if _, err := r.Get("x"); err != scheduling.ErrNoNode {
+		t.Fatal("Empty ring should have no node")
+	}
+
+	if _, err := r.GetN("x", 1); err != scheduling.ErrNoNode {
+		t.Fatal("Empty ring should have no node")
+	}
+
+	// Zero weight nodes are drained
+	r.Add(&node{"a", 0})
+	if _, err := r.Get("x"); err != scheduling.ErrNoNode || r.Len() != 1 {
+		t.Fatal("Zero weight node should own no key")
+	}
+}
+
+func BenchmarkRingGet(b *testing.B) {
+	var nodes []scheduling.NamedNode
+	for i := 0; i < 100; i++ {
+		nodes = append(nodes, &node{"node-" + strconv.Itoa(i), 1})
+	}
+
+	r := New(0, nodes...)
+
+	for i := 0; i < b.N; i++ {
+		r.Get("some-key")
+	}
+}
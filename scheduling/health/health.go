@@ -0,0 +1,231 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package health wraps a balancer to passively eject failing nodes.
+//
+// A node failing MaxFailures times in a row is ejected for BaseEjection.
+// Once the ejection expires, one request is let through as a probe: if it
+// succeeds the node is back, otherwise it's ejected again for twice as
+// long, up to MaxEjection. At most MaxEjectedPercent of the nodes are
+// ejected at the same time, so a global outage doesn't eject them all.
+//
+// Requests sent before the ejection may end while the probe is in flight,
+// Done tells them apart from the probe by when they started, the time of
+// Done minus the latency reported. Their results are left out.
+package health
+
+import (
+	"errors"
+	"github.com/flatpeach/coconut/scheduling"
+	"sync"
+	"time"
+)
+
+// ErrEjected is reported to the wrapped balancer for the nodes it picked
+// while they were ejected
+var ErrEjected = errors.New("health: node ejected")
+
+type Health struct {
+	mu sync.Mutex
+
+	balancer scheduling.Balancer
+	option   *Option
+
+	states  map[scheduling.Node]*state // every node picked so far
+	ejected int                        // count of ejected nodes
+}
+
+type state struct {
+	failures  int // consecutive failures
+	ejections int // consecutive ejections, the backoff exponent
+	ejected   bool
+	since     time.Time // start of the ejection
+	until     time.Time // end of the ejection
+	probing   bool      // a probe is in flight
+	probeAt   time.Time // when the probe was sent
+}
+
+// fromProbe tells whether a request started at start is the probe. Only
+// the probe is sent while ejected, older requests started before: the
+// middle of both leaves room for latencies measured a bit off.
+func (s *state) fromProbe(start time.Time) bool {
+	return !start.Before(s.since.Add(s.probeAt.Sub(s.since) / 2))
+}
+
+// Return back a new Health wrapping balancer
+func New(balancer scheduling.Balancer, option *Option) *Health {
+	if option == nil {
+		option = &Option{}
+	}
+
+	return &Health{
+		balancer: balancer,
+		option:   option.normalize(),
+		states:   make(map[scheduling.Node]*state),
+	}
+}
+
+func (h *Health) stateOf(node scheduling.Node) *state {
+	s, ok := h.states[node]
+	if !ok {
+		s = &state{}
+		h.states[node] = s
+	}
+
+	return s
+}
+
+// admit tells whether a request can be sent to node, starting a probe if
+// its ejection expired
+func (h *Health) admit(s *state, now time.Time) bool {
+	if !s.ejected {
+		return true
+	}
+
+	if s.probing || now.Before(s.until) {
+		return false
+	}
+
+	s.probing, s.probeAt = true, now
+
+	return true
+}
+
+// Pick implements the scheduling.Balancer interface. Ejected nodes are
+// skipped until the wrapped balancer gives one not ejected, unless every
+// known node is ejected: then it fails open and the request is a probe of
+// the node picked.
+func (h *Health) Pick() (scheduling.Node, error) {
+	// Skipped nodes are held until the end, so balancers counting the
+	// requests in flight don't pick them again
+	var skipped []scheduling.Node
+
+	defer func() {
+		for _, node := range skipped {
+			h.balancer.Done(node, 0, ErrEjected)
+		}
+	}()
+
+	for {
+		node, err := h.balancer.Pick()
+		if err != nil {
+			return nil, err
+		}
+
+		h.mu.Lock()
+
+		s := h.stateOf(node)
+		now := h.option.Clock.Now()
+		ok := h.admit(s, now)
+
+		if !ok && h.ejected == len(h.states) {
+			if !s.probing {
+				s.probing, s.probeAt = true, now
+			}
+
+			ok = true
+		}
+
+		h.mu.Unlock()
+
+		if ok {
+			return node, nil
+		}
+
+		skipped = append(skipped, node)
+	}
+}
+
+// backoff returns how long the n-th consecutive ejection lasts
+func (h *Health) backoff(n int) time.Duration {
+	d := h.option.BaseEjection
+
+	for i := 1; i < n && d < h.option.MaxEjection; i++ {
+		d *= 2
+	}
+
+	if d > h.option.MaxEjection {
+		d = h.option.MaxEjection
+	}
+
+	return d
+}
+
+// canEject tells whether one more node can be ejected
+func (h *Health) canEject() bool {
+	return (h.ejected+1)*100 <= h.option.MaxEjectedPercent*len(h.states)
+}
+
+// Done implements the scheduling.Balancer interface, latency must be
+// measured from the Pick of node
+func (h *Health) Done(node scheduling.Node, latency time.Duration, err error) {
+	h.balancer.Done(node, latency, err)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Only the nodes picked are known, see Remove
+	s, ok := h.states[node]
+	if !ok {
+		return
+	}
+
+	now := h.option.Clock.Now()
+
+	if s.ejected && s.probing && !s.fromProbe(now.Add(-latency)) {
+		return
+	}
+
+	if err == nil {
+		s.failures = 0
+
+		if s.ejected && s.probing {
+			s.ejected, s.probing, s.ejections = false, false, 0
+			h.ejected--
+		}
+
+		return
+	}
+
+	s.failures++
+
+	switch {
+	case s.ejected && s.probing:
+		// Failed probe, back out for longer
+		s.probing = false
+		s.ejections++
+		s.since, s.until = now, now.Add(h.backoff(s.ejections))
+
+	case !s.ejected && s.failures >= h.option.MaxFailures && h.canEject():
+		s.ejected = true
+		s.ejections++
+		s.since, s.until = now, now.Add(h.backoff(s.ejections))
+		h.ejected++
+	}
+}
+
+// Ejected tells whether node is ejected
+func (h *Health) Ejected(node scheduling.Node) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.states[node]
+	return ok && s.ejected
+}
+
+// Remove forgets the health of node, to be called once it's removed from
+// the wrapped balancer. Pick looks for a node not ejected as long as one is
+// known, it never ends if that node can't be picked anymore.
+func (h *Health) Remove(node scheduling.Node) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if s, ok := h.states[node]; ok {
+		if s.ejected {
+			h.ejected--
+		}
+
+		delete(h.states, node)
+	}
+}
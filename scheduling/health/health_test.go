@@ -0,0 +1,403 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package health
+
+import (
+	"errors"
+	"github.com/flatpeach/coconut/scheduling"
+	"github.com/flatpeach/coconut/scheduling/leastconn"
+	"github.com/flatpeach/coconut/scheduling/p2c"
+	"github.com/flatpeach/coconut/scheduling/random"
+	"github.com/flatpeach/coconut/scheduling/roundrobin"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+type node struct {
+	name   string
+	weight int
+}
+
+func (n *node) Weight() int {
+	return n.weight
+}
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+var (
+	_ scheduling.Balancer = (*Health)(nil)
+
+	errFailed = errors.New("failed")
+)
+
+func newNodes(n int) []scheduling.Node {
+	var nodes []scheduling.Node
+	for i := 0; i < n; i++ {
+		nodes = append(nodes, &node{string(rune('a' + i)), 1})
+	}
+
+	return nodes
+}
+
+// picks returns the names of the next n nodes, reporting success. The
+// clock doesn't move in between, so neither does the latency.
+func picks(t *testing.T, h *Health, n int) string {
+	s := ""
+	for i := 0; i < n; i++ {
+		n, err := h.Pick()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		h.Done(n, 0, nil)
+		s += n.(*node).name
+	}
+
+	return s
+}
+
+// fail reports n failures of node
+func fail(h *Health, node scheduling.Node, n int) {
+	for i := 0; i < n; i++ {
+		h.Done(node, time.Millisecond, errFailed)
+	}
+}
+
+func TestHealthEject(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	nodes := newNodes(4)
+	h := New(roundrobin.New(nodes...), &Option{MaxFailures: 3, BaseEjection: 10 * time.Second, Clock: clock})
+
+	if s := picks(t, h, 4); s != "abcd" {
+		t.Fatalf("Unexpected sequence %s", s)
+	}
+
+	// Failures must be consecutive
+	fail(h, nodes[0], 2)
+	h.Done(nodes[0], time.Millisecond, nil)
+	fail(h, nodes[0], 2)
+
+	if h.Ejected(nodes[0]) {
+		t.Fatal("a should not be ejected yet")
+	}
+
+	fail(h, nodes[0], 1)
+	if !h.Ejected(nodes[0]) {
+		t.Fatal("a should be ejected")
+	}
+
+	if s := picks(t, h, 6); s != "bcdbcd" {
+		t.Fatalf("a should be skipped, got %s", s)
+	}
+
+	// The ejection expires, a gets one probe which succeeds
+	clock.Advance(10 * time.Second)
+
+	if s := picks(t, h, 4); s != "abcd" {
+		t.Fatalf("a should be probed, got %s", s)
+	}
+
+	if h.Ejected(nodes[0]) {
+		t.Fatal("a should be back")
+	}
+}
+
+func TestHealthBackoff(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	nodes := newNodes(2)
+	h := New(roundrobin.New(nodes...), &Option{
+		MaxFailures:  1,
+		BaseEjection: 10 * time.Second,
+		MaxEjection:  35 * time.Second,
+		Clock:        clock,
+	})
+
+	picks(t, h, 2)
+	fail(h, nodes[0], 1)
+
+	// Every failed probe doubles the ejection, up to MaxEjection
+	for _, d := range []time.Duration{10, 20, 35, 35} {
+		clock.Advance(d*time.Second - time.Millisecond)
+
+		if s := picks(t, h, 2); s != "bb" {
+			t.Fatalf("a should be ejected for %ds, got %s", d, s)
+		}
+
+		clock.Advance(time.Millisecond)
+
+		node, _ := h.Pick()
+		if node != nodes[0] {
+			t.Fatalf("a should be probed after %ds", d)
+		}
+
+		// Only one probe at a time
+		if s := picks(t, h, 2); s != "bb" {
+			t.Fatalf("Only one probe should go to a, got %s", s)
+		}
+
+		h.Done(node, time.Millisecond, errFailed)
+
+		if !h.Ejected(nodes[0]) {
+			t.Fatal("a should stay ejected")
+		}
+	}
+
+	// A successful probe resets the backoff
+	clock.Advance(35 * time.Second)
+	if s := picks(t, h, 2); s != "ab" {
+		t.Fatalf("a should be probed, got %s", s)
+	}
+
+	fail(h, nodes[0], 1)
+	clock.Advance(10 * time.Second)
+
+	if s := picks(t, h, 2); s != "ab" {
+		t.Fatalf("a should be ejected for 10s only, got %s", s)
+	}
+}
+
+func TestHealthPanicThreshold(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	nodes := newNodes(4)
+	h := New(roundrobin.New(nodes...), &Option{MaxFailures: 1, Clock: clock})
+
+	picks(t, h, 4)
+
+	// Everything fails, only half of the nodes are ejected
+	for _, n := range nodes {
+		fail(h, n, 1)
+	}
+
+	ejected := 0
+	for _, n := range nodes {
+		if h.Ejected(n) {
+			ejected++
+		}
+	}
+
+	if ejected != 2 {
+		t.Fatalf("2 nodes should be ejected, got %d", ejected)
+	}
+
+	if s := picks(t, h, 4); s != "cdcd" {
+		t.Fatalf("Unexpected sequence %s", s)
+	}
+
+	// Once they are forgotten, another one can go
+	h.Remove(nodes[0])
+	h.Remove(nodes[1])
+	fail(h, nodes[2], 1)
+
+	if !h.Ejected(nodes[2]) {
+		t.Fatal("c should be ejected")
+	}
+}
+
+func TestHealthFailOpen(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	a := &node{"a", 1}
+	h := New(roundrobin.New(a), &Option{MaxFailures: 1, MaxEjectedPercent: 100, Clock: clock})
+
+	picks(t, h, 1)
+	fail(h, a, 1)
+
+	if !h.Ejected(a) {
+		t.Fatal("a should be ejected")
+	}
+
+	// Nothing else to pick, a is probed and comes back
+	if s := picks(t, h, 3); s != "aaa" {
+		t.Fatalf("a should be used anyway, got %s", s)
+	}
+
+	if h.Ejected(a) {
+		t.Fatal("a should be back")
+	}
+}
+
+func TestHealthUnevenWeights(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	a, b, c := &node{"a", 20}, &node{"b", 1}, &node{"c", 1}
+	h := New(roundrobin.New(a, b, c), &Option{MaxFailures: 1, Clock: clock})
+
+	// Round-robin gives a 20 times in a row
+	picks(t, h, 22)
+	fail(h, a, 1)
+
+	if !h.Ejected(a) {
+		t.Fatal("a should be ejected")
+	}
+
+	counts := map[string]int{}
+	for _, r := range picks(t, h, 220) {
+		counts[string(r)]++
+	}
+
+	if counts["a"] != 0 || counts["b"] != 110 || counts["c"] != 110 {
+		t.Fatalf("a should get no traffic, got %v", counts)
+	}
+}
+
+func TestHealthLateResults(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	nodes := newNodes(2)
+	h := New(roundrobin.New(nodes...), &Option{
+		MaxFailures:       1,
+		MaxEjectedPercent: 50,
+		BaseEjection:      10 * time.Second,
+		Clock:             clock,
+	})
+
+	// Two requests to a are in flight when it's ejected
+	var old []scheduling.Node
+	for i := 0; i < 2; i++ {
+		node, _ := h.Pick()
+		old = append(old, node)
+		picks(t, h, 1)
+	}
+
+	fail(h, nodes[0], 1)
+	clock.Advance(10 * time.Second)
+
+	probe, _ := h.Pick()
+	if probe != nodes[0] {
+		t.Fatal("a should be probed")
+	}
+
+	// An old failure doesn't back off, the probe decides
+	h.Done(old[0], 10*time.Second, errFailed)
+	h.Done(probe, time.Millisecond, nil)
+
+	if h.Ejected(nodes[0]) {
+		t.Fatal("a should be back")
+	}
+
+	// b comes next, the round is back to a when ejected again
+	picks(t, h, 1)
+	fail(h, nodes[0], 1)
+	clock.Advance(10 * time.Second)
+
+	probe, _ = h.Pick()
+	if probe != nodes[0] {
+		t.Fatal("a should be probed again")
+	}
+
+	// An old success doesn't bring a back, the probe decides
+	h.Done(old[1], 20*time.Second, nil)
+
+	if !h.Ejected(nodes[0]) {
+		t.Fatal("a should still be ejected")
+	}
+
+	h.Done(probe, time.Millisecond, errFailed)
+	clock.Advance(20*time.Second - time.Millisecond)
+
+	if s := picks(t, h, 2); s != "bb" {
+		t.Fatalf("a should be ejected for 20s, got %s", s)
+	}
+
+	clock.Advance(time.Millisecond)
+	if s := picks(t, h, 2); s != "ab" {
+		t.Fatalf("a should be probed, got %s", s)
+	}
+}
+
+func TestHealthRandomized(t *testing.T) {
+	balancers := map[string]func(nodes ...scheduling.Node) scheduling.Balancer{
+		"random": func(nodes ...scheduling.Node) scheduling.Balancer {
+			return random.New(rand.NewSource(1), nodes...)
+		},
+		"p2c": func(nodes ...scheduling.Node) scheduling.Balancer {
+			return p2c.New(rand.NewSource(1), nodes...)
+		},
+	}
+
+	for name, newBalancer := range balancers {
+		clock := &fakeClock{now: time.Unix(0, 0)}
+		nodes := newNodes(2)
+		h := New(newBalancer(nodes...), &Option{MaxFailures: 1, Clock: clock})
+
+		for !h.Ejected(nodes[0]) {
+			if node, err := h.Pick(); err == nil && node == nodes[0] {
+				h.Done(node, time.Millisecond, errFailed)
+			} else if err == nil {
+				h.Done(node, time.Millisecond, nil)
+			}
+		}
+
+		// The wrapped balancer gives a many times in a row, Pick must not give up
+		for i := 0; i < 10000; i++ {
+			node, err := h.Pick()
+			if err != nil || node != nodes[1] {
+				t.Fatalf("%s: only b should be picked, got %v and %v", name, node, err)
+			}
+
+			h.Done(node, time.Millisecond, nil)
+		}
+	}
+}
+
+func TestHealthUnknown(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	a, b := &node{"a", 1}, &node{"b", 1}
+	h := New(roundrobin.New(a), &Option{MaxFailures: 1, MaxEjectedPercent: 100, Clock: clock})
+
+	// b is never picked by the wrapped balancer, so it's not known
+	h.Done(b, time.Millisecond, nil)
+	picks(t, h, 1)
+	fail(h, a, 1)
+
+	if !h.Ejected(a) || h.Ejected(b) {
+		t.Fatal("Only a should be ejected")
+	}
+
+	if s := picks(t, h, 1); s != "a" {
+		t.Fatalf("a should be used anyway, got %s", s)
+	}
+}
+
+func TestHealthDone(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	nodes := newNodes(3)
+	lc := leastconn.New(nodes...)
+	h := New(lc, &Option{MaxFailures: 1, Clock: clock})
+
+	picks(t, h, 3)
+	fail(h, nodes[0], 1)
+
+	// Skipped picks are reported done to the wrapped balancer
+	var inFlight []scheduling.Node
+	for i := 0; i < 10; i++ {
+		node, err := h.Pick()
+		if err != nil || node == nodes[0] {
+			t.Fatal("a should be skipped")
+		}
+
+		inFlight = append(inFlight, node)
+	}
+
+	if lc.Active(nodes[0]) != 0 || lc.Active(nodes[1])+lc.Active(nodes[2]) != 10 {
+		t.Fatal("Only the requests sent should be in flight")
+	}
+
+	for _, node := range inFlight {
+		h.Done(node, time.Millisecond, nil)
+	}
+
+	if _, err := New(leastconn.New(), nil).Pick(); err != scheduling.ErrNoNode {
+		t.Fatal("Errors of the wrapped balancer should be returned")
+	}
+}
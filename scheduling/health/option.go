@@ -0,0 +1,81 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package health
+
+import (
+	"time"
+)
+
+// Clock tells the time, tests replace it by a fake one
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// Option used to construct the Health
+type Option struct {
+	// Consecutive failures ejecting a node, zero means 5
+	MaxFailures int
+
+	// Length of the first ejection, doubled at each failed probe, zero
+	// means 30 seconds
+	BaseEjection time.Duration
+
+	// Longest ejection, zero means 5 minutes
+	MaxEjection time.Duration
+
+	// Percent of the nodes which can be ejected at the same time, zero
+	// means 50. Past it failing nodes stay in rotation.
+	MaxEjectedPercent int
+
+	// Clock used to time the ejections, nil means the real time
+	Clock Clock
+}
+
+func (o *Option) clone() *Option {
+	return &Option{
+		MaxFailures:       o.MaxFailures,
+		BaseEjection:      o.BaseEjection,
+		MaxEjection:       o.MaxEjection,
+		MaxEjectedPercent: o.MaxEjectedPercent,
+		Clock:             o.Clock,
+	}
+}
+
+// normalize fixes the zero values
+func (o *Option) normalize() *Option {
+	o = o.clone()
+
+	if o.MaxFailures <= 0 {
+		o.MaxFailures = 5
+	}
+
+	if o.BaseEjection <= 0 {
+		o.BaseEjection = 30 * time.Second
+	}
+
+	if o.MaxEjection <= 0 {
+		o.MaxEjection = 5 * time.Minute
+	}
+
+	if o.MaxEjection < o.BaseEjection {
+		o.MaxEjection = o.BaseEjection
+	}
+
+	if o.MaxEjectedPercent <= 0 {
+		o.MaxEjectedPercent = 50
+	}
+
+	if o.Clock == nil {
+		o.Clock = realClock{}
+	}
+
+	return o
+}
@@ -0,0 +1,138 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package jump provides the jump consistent hash, see "A Fast, Minimal
+// Memory, Consistent Hash Algorithm" by Lamping and Veach.
+//
+// Keys are spread evenly over numbered buckets, and growing from n to n+1
+// buckets only moves 1/(n+1) of the keys, all of them to the new bucket.
+package jump
+
+import (
+	"github.com/flatpeach/coconut/hash/murmur3"
+	"github.com/flatpeach/coconut/scheduling"
+	"sync"
+)
+
+// Hash returns the bucket of key among buckets buckets, numbered from 0. It
+// returns -1 if there is no bucket.
+func Hash(key uint64, buckets int) int {
+	if buckets <= 0 {
+		return -1
+	}
+
+	b, j := int64(-1), int64(0)
+
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(1<<31) / float64((key>>33)+1)))
+	}
+
+	return int(b)
+}
+
+// Jump maps keys to nodes, node i of the list being bucket i. Every node
+// has the same share of keys whatever its weight, but zero weight nodes
+// are drained: their keys are spread over the other nodes.
+type Jump struct {
+	mu sync.Mutex
+
+	nodes []scheduling.Node
+}
+
+// How many times a key drawing a drained node is hashed again, before
+// falling back to the next node of positive weight
+const maxRetries = 32
+
+func New(nodes ...scheduling.Node) *Jump {
+	j := &Jump{
+		nodes: make([]scheduling.Node, len(nodes)),
+	}
+
+	copy(j.nodes, nodes)
+
+	return j
+}
+
+// mix scrambles key for the next retry
+func mix(key uint64) uint64 {
+	key ^= key >> 33
+	key *= 0xff51afd7ed558ccd
+	key ^= key >> 33
+
+	return key
+}
+
+// Get returns the node key belongs to
+func (j *Jump) Get(key string) (scheduling.Node, error) {
+	return j.GetHash(murmur3.Sum64([]byte(key), 0))
+}
+
+// GetHash returns the node of a key already hashed
+func (j *Jump) GetHash(key uint64) (scheduling.Node, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	b := Hash(key, len(j.nodes))
+	if b < 0 {
+		return nil, scheduling.ErrNoNode
+	}
+
+	for i := 0; i < maxRetries; i++ {
+		if j.nodes[b].Weight() > 0 {
+			return j.nodes[b], nil
+		}
+
+		key = mix(key + 1)
+		b = Hash(key, len(j.nodes))
+	}
+
+	for i := range j.nodes {
+		if node := j.nodes[(b+i)%len(j.nodes)]; node.Weight() > 0 {
+			return node, nil
+		}
+	}
+
+	return nil, scheduling.ErrNoNode
+}
+
+// Add one node as the last bucket
+func (j *Jump) Add(node scheduling.Node) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.nodes = append(j.nodes, node)
+}
+
+// Remove one node, reports whether it was found. Only removing the last
+// node is minimal, otherwise the last node takes the bucket of the removed
+// one and its own keys move too. Draining a node with a zero weight doesn't
+// have this cost.
+func (j *Jump) Remove(node scheduling.Node) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for i, n := range j.nodes {
+		if n == node {
+			last := len(j.nodes) - 1
+
+			j.nodes[i] = j.nodes[last]
+			j.nodes[last] = nil
+			j.nodes = j.nodes[:last]
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// Len returns how many buckets there are
+func (j *Jump) Len() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return len(j.nodes)
+}
@@ -0,0 +1,199 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jump
+
+import (
+	"github.com/flatpeach/coconut/hash/murmur3"
+	"github.com/flatpeach/coconut/scheduling"
+	"strconv"
+	"testing"
+)
+
+type node struct {
+	name   string
+	weight int
+}
+
+func (n *node) Weight() int {
+	return n.weight
+}
+
+func TestHashReference(t *testing.T) {
+	// Generated by the reference code of the paper
+	cases := []struct {
+		key     uint64
+		buckets []int
+	}{
+		{0, []int{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+		{1, []int{0, 0, 0, 0, 0, 0, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 17, 17}},
+		{0xdeadbeef, []int{0, 1, 2, 3, 3, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 16, 16, 16}},
+		{0x0ddc0ffeebadf00d, []int{0, 1, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 15, 15, 15, 15}},
+	}
+
+	for _, c := range cases {
+		for i, want := range c.buckets {
+			if b := Hash(c.key, i+1); b != want {
+				t.Fatalf("Hash(%#x, %d) should be %d, got %d", c.key, i+1, want, b)
+			}
+		}
+	}
+
+	// Same as Guava
+	golden := []int{0, 55, 62, 8, 45, 59, 86, 97, 82, 59, 73, 37, 17, 56, 86, 21, 90, 37, 38, 83}
+	for i, want := range golden {
+		if b := Hash(uint64(i), 100); b != want {
+			t.Fatalf("Hash(%d, 100) should be %d, got %d", i, want, b)
+		}
+	}
+
+	if Hash(1, 0) != -1 {
+		t.Fatal("No bucket should give -1")
+	}
+}
+
+const keys = 100000
+
+func key(i int) uint64 {
+	return murmur3.Sum64([]byte("key-"+strconv.Itoa(i)), 0)
+}
+
+func TestHashBalance(t *testing.T) {
+	for _, n := range []int{3, 10, 17} {
+		counts := make([]int, n)
+		for i := 0; i < keys; i++ {
+			counts[Hash(key(i), n)]++
+		}
+
+		for b, c := range counts {
+			if c < keys/n*95/100 || c > keys/n*105/100 {
+				t.Fatalf("Bucket %d of %d should have about %d keys, got %d", b, n, keys/n, c)
+			}
+		}
+	}
+}
+
+func TestHashGrow(t *testing.T) {
+	for n := 1; n < 20; n++ {
+		moved := 0
+
+		for i := 0; i < keys; i++ {
+			before, after := Hash(key(i), n), Hash(key(i), n+1)
+			if before == after {
+				continue
+			}
+
+			// Keys only move to the new bucket
+			if after != n {
+				t.Fatalf("Key %d moved from %d to %d", i, before, after)
+			}
+
+			moved++
+		}
+
+		want := keys / (n + 1)
+		if moved < want*90/100 || moved > want*110/100 {
+			t.Fatalf("Growing to %d buckets should move about %d keys, got %d", n+1, want, moved)
+		}
+	}
+}
+
+func TestJump(t *testing.T) {
+	var nodes []scheduling.Node
+	for i := 0; i < 5; i++ {
+		nodes = append(nodes, &node{strconv.Itoa(i), 1})
+	}
+
+	j := New(nodes...)
+
+	get := func(i int) scheduling.Node {
+		n, err := j.GetHash(key(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return n
+	}
+
+	before := make([]scheduling.Node, keys)
+	for i := range before {
+		before[i] = get(i)
+
+		if s, _ := j.Get("key-" + strconv.Itoa(i)); s != before[i] {
+			t.Fatal("Get should hash the key the same way")
+		}
+	}
+
+	// Drain node 1, only its keys move and they are spread
+	nodes[1].(*node).weight = 0
+
+	counts := map[scheduling.Node]int{}
+	for i := range before {
+		after := get(i)
+
+		if before[i] != nodes[1] && after != before[i] {
+			t.Fatalf("Key %d of a live node moved", i)
+		}
+
+		if before[i] == nodes[1] {
+			counts[after]++
+		}
+	}
+
+	if counts[nodes[1]] != 0 || len(counts) != 4 {
+		t.Fatalf("Keys of the drained node should go to the 4 others, got %v", counts)
+	}
+
+	for n, c := range counts {
+		if c < keys/5/4*80/100 || c > keys/5/4*120/100 {
+			t.Fatalf("%s should get about %d keys, got %d", n.(*node).name, keys/5/4, c)
+		}
+	}
+
+	nodes[1].(*node).weight = 1
+
+	// Removing the last node is minimal
+	if !j.Remove(nodes[4]) || j.Remove(nodes[4]) || j.Len() != 4 {
+		t.Fatal("Last node should be removed once")
+	}
+
+	for i := range before {
+		if before[i] != nodes[4] && get(i) != before[i] {
+			t.Fatalf("Key %d of a live node moved", i)
+		}
+	}
+
+	j.Add(nodes[4])
+	for i := range before {
+		if get(i) != before[i] {
+			t.Fatalf("Key %d should be back on its node", i)
+		}
+	}
+}
+
+func TestJumpEmpty(t *testing.T) {
+	if _, err := New().Get("x"); err != scheduling.ErrNoNode {
+		t.Fatal("No node should be found")
+	}
+
+	if _, err := New(&node{"a", 0}, &node{"b", 0}).Get("x"); err != scheduling.ErrNoNode {
+		t.Fatal("Drained nodes should not be found")
+	}
+
+	// One live node takes every key
+	a := &node{"a", 1}
+	j := New(&node{"b", 0}, &node{"c", 0}, a, &node{"d", 0})
+
+	for i := 0; i < 1000; i++ {
+		if n, _ := j.GetHash(key(i)); n != a {
+			t.Fatal("a should take every key")
+		}
+	}
+}
+
+func BenchmarkHash(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		Hash(uint64(i), 1000)
+	}
+}
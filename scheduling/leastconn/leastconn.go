@@ -0,0 +1,134 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package leastconn picks the node having the least requests in flight
+// relatively to its weight.
+package leastconn
+
+import (
+	"github.com/flatpeach/coconut/scheduling"
+	"sync"
+	"time"
+)
+
+type LeastConn struct {
+	mu sync.Mutex
+
+	peers []*peer
+	index map[scheduling.Node]*peer
+	next  int // where to start looking, so ties go round
+}
+
+type peer struct {
+	node   scheduling.Node
+	active int // requests in flight
+}
+
+func New(nodes ...scheduling.Node) *LeastConn {
+	lc := &LeastConn{
+		index: make(map[scheduling.Node]*peer),
+	}
+
+	for _, node := range nodes {
+		lc.add(node)
+	}
+
+	return lc
+}
+
+func (lc *LeastConn) add(node scheduling.Node) {
+	if _, ok := lc.index[node]; ok {
+		return
+	}
+
+	p := &peer{node: node}
+
+	lc.peers = append(lc.peers, p)
+	lc.index[node] = p
+}
+
+// Pick implements the scheduling.Balancer interface
+func (lc *LeastConn) Pick() (scheduling.Node, error) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	var best *peer
+	bestWeight, start := 0, lc.next
+
+	for i := range lc.peers {
+		p := lc.peers[(start+i)%len(lc.peers)]
+
+		weight := p.node.Weight()
+		if weight <= 0 {
+			continue
+		}
+
+		// p.active/weight < best.active/bestWeight
+		if best == nil || p.active*bestWeight < best.active*weight {
+			best, bestWeight = p, weight
+			lc.next = (start + i + 1) % len(lc.peers)
+		}
+	}
+
+	if best == nil {
+		return nil, scheduling.ErrNoNode
+	}
+
+	best.active++
+
+	return best.node, nil
+}
+
+// Done implements the scheduling.Balancer interface
+func (lc *LeastConn) Done(node scheduling.Node, latency time.Duration, err error) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	if p, ok := lc.index[node]; ok && p.active > 0 {
+		p.active--
+	}
+}
+
+// Active returns how many requests are in flight on node
+func (lc *LeastConn) Active(node scheduling.Node) int {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	if p, ok := lc.index[node]; ok {
+		return p.active
+	}
+
+	return 0
+}
+
+// Add one node, adding it again does nothing
+func (lc *LeastConn) Add(node scheduling.Node) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	lc.add(node)
+}
+
+// Remove one node, reports whether it was found
+func (lc *LeastConn) Remove(node scheduling.Node) bool {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	if _, ok := lc.index[node]; !ok {
+		return false
+	}
+
+	delete(lc.index, node)
+
+	for i, p := range lc.peers {
+		if p.node == node {
+			copy(lc.peers[i:], lc.peers[i+1:])
+			lc.peers[len(lc.peers)-1] = nil
+			lc.peers = lc.peers[:len(lc.peers)-1]
+			break
+		}
+	}
+
+	return true
+}
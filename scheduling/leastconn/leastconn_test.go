@@ -0,0 +1,116 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package leastconn
+
+import (
+	"github.com/flatpeach/coconut/scheduling"
+	"testing"
+)
+
+type node struct {
+	name   string
+	weight int
+}
+
+func (n *node) Weight() int {
+	return n.weight
+}
+
+var _ scheduling.Balancer = (*LeastConn)(nil)
+
+func pick(t *testing.T, lc *LeastConn) *node {
+	n, err := lc.Pick()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return n.(*node)
+}
+
+func TestLeastConnWeighted(t *testing.T) {
+	a, b, c := &node{"a", 1}, &node{"b", 2}, &node{"c", 3}
+	lc := New(a, b, c)
+
+	// Requests never complete, in flight counts follow the weights
+	for i := 0; i < 600; i++ {
+		pick(t, lc)
+	}
+
+	if lc.Active(a) != 100 || lc.Active(b) != 200 || lc.Active(c) != 300 {
+		t.Fatalf("Unexpected in flight counts %d %d %d", lc.Active(a), lc.Active(b), lc.Active(c))
+	}
+}
+
+func TestLeastConnDone(t *testing.T) {
+	a, b := &node{"a", 1}, &node{"b", 1}
+	lc := New(a, b)
+
+	x, y := pick(t, lc), pick(t, lc)
+	if x == y {
+		t.Fatal("Both nodes should be busy")
+	}
+
+	lc.Done(y, 0, nil)
+
+	if pick(t, lc) != y {
+		t.Fatalf("%s is idle and should be picked", y.name)
+	}
+
+	// Extra Done never make counts negative
+	lc.Done(a, 0, nil)
+	lc.Done(a, 0, nil)
+	lc.Done(a, 0, nil)
+	if lc.Active(a) != 0 {
+		t.Fatal("a should be idle")
+	}
+
+	lc.Done(&node{"c", 1}, 0, nil)
+}
+
+func TestLeastConnTies(t *testing.T) {
+	lc := New(&node{"a", 1}, &node{"b", 1}, &node{"c", 1})
+
+	// Always idle, ties go round
+	s := ""
+	for i := 0; i < 6; i++ {
+		n := pick(t, lc)
+		lc.Done(n, 0, nil)
+		s += n.name
+	}
+
+	if s != "abcabc" {
+		t.Fatalf("Unexpected sequence %s", s)
+	}
+}
+
+func TestLeastConnMembership(t *testing.T) {
+	a, b := &node{"a", 0}, &node{"b", 1}
+	lc := New(a)
+
+	if _, err := lc.Pick(); err != scheduling.ErrNoNode {
+		t.Fatal("Zero weight node should not be picked")
+	}
+
+	lc.Add(b)
+	lc.Add(b)
+
+	for i := 0; i < 3; i++ {
+		if pick(t, lc) != b {
+			t.Fatal("Only b should be picked")
+		}
+	}
+
+	if !lc.Remove(b) || lc.Remove(b) {
+		t.Fatal("b should be removed once")
+	}
+
+	if _, err := lc.Pick(); err != scheduling.ErrNoNode {
+		t.Fatal("No node should be left")
+	}
+
+	if _, err := New().Pick(); err != scheduling.ErrNoNode {
+		t.Fatal("No node should be picked")
+	}
+}
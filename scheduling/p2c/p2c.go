@@ -0,0 +1,174 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package p2c implements the power of two choices: two nodes are drawn at
+// random and the less loaded one is picked.
+//
+// The load of a node is its requests in flight times its average latency,
+// divided by its weight. The average is an exponentially weighted moving
+// average of the latencies reported to Done. A failed request counts as
+// FailurePenalty times its latency, or times the average if it's higher.
+package p2c
+
+import (
+	"github.com/flatpeach/coconut/scheduling"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	// Weight of the last latency in the moving average
+	Decay = 0.3
+
+	// How much a failure costs compared to the average latency
+	FailurePenalty = 10
+)
+
+type P2C struct {
+	mu sync.Mutex
+
+	peers []*peer
+	index map[scheduling.Node]*peer
+	rand  *rand.Rand
+}
+
+type peer struct {
+	node    scheduling.Node
+	active  int     // requests in flight
+	latency float64 // moving average in nanoseconds
+}
+
+// Return back a new P2C drawing from src, a nil src is seeded with the
+// current time
+func New(src rand.Source, nodes ...scheduling.Node) *P2C {
+	if src == nil {
+		src = rand.NewSource(time.Now().UnixNano())
+	}
+
+	b := &P2C{
+		index: make(map[scheduling.Node]*peer),
+		rand:  rand.New(src),
+	}
+
+	for _, node := range nodes {
+		b.add(node)
+	}
+
+	return b
+}
+
+func (b *P2C) add(node scheduling.Node) {
+	if _, ok := b.index[node]; ok {
+		return
+	}
+
+	p := &peer{node: node}
+
+	b.peers = append(b.peers, p)
+	b.index[node] = p
+}
+
+// load of p, the nanosecond added keeps fresh nodes comparable
+func (p *peer) load(weight int) float64 {
+	return float64(p.active+1) * (p.latency + 1) / float64(weight)
+}
+
+// Pick implements the scheduling.Balancer interface
+func (b *P2C) Pick() (scheduling.Node, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var candidates []*peer
+	var weights []int
+
+	for _, p := range b.peers {
+		if w := p.node.Weight(); w > 0 {
+			candidates = append(candidates, p)
+			weights = append(weights, w)
+		}
+	}
+
+	var best int
+
+	switch len(candidates) {
+	case 0:
+		return nil, scheduling.ErrNoNode
+	case 1:
+		best = 0
+	default:
+		x := b.rand.Intn(len(candidates))
+		y := b.rand.Intn(len(candidates) - 1)
+		if y >= x {
+			y++
+		}
+
+		best = x
+		if candidates[y].load(weights[y]) < candidates[x].load(weights[x]) {
+			best = y
+		}
+	}
+
+	candidates[best].active++
+
+	return candidates[best].node, nil
+}
+
+// Done implements the scheduling.Balancer interface
+func (b *P2C) Done(node scheduling.Node, latency time.Duration, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	p, ok := b.index[node]
+	if !ok {
+		return
+	}
+
+	if p.active > 0 {
+		p.active--
+	}
+
+	sample := float64(latency)
+	if err != nil {
+		sample = FailurePenalty * math.Max(p.latency, sample)
+	}
+
+	if p.latency == 0 {
+		p.latency = sample
+	} else {
+		p.latency = Decay*sample + (1-Decay)*p.latency
+	}
+}
+
+// Add one node, adding it again does nothing
+func (b *P2C) Add(node scheduling.Node) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.add(node)
+}
+
+// Remove one node, reports whether it was found
+func (b *P2C) Remove(node scheduling.Node) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.index[node]; !ok {
+		return false
+	}
+
+	delete(b.index, node)
+
+	for i, p := range b.peers {
+		if p.node == node {
+			copy(b.peers[i:], b.peers[i+1:])
+			b.peers[len(b.peers)-1] = nil
+			b.peers = b.peers[:len(b.peers)-1]
+			break
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,141 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package p2c
+
+import (
+	"errors"
+	"github.com/flatpeach/coconut/scheduling"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+type node struct {
+	name   string
+	weight int
+}
+
+func (n *node) Weight() int {
+	return n.weight
+}
+
+var _ scheduling.Balancer = (*P2C)(nil)
+
+func pick(t *testing.T, b *P2C) *node {
+	n, err := b.Pick()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return n.(*node)
+}
+
+func TestP2CInFlight(t *testing.T) {
+	nodes := []*node{{"a", 1}, {"b", 1}, {"c", 1}, {"d", 1}, {"e", 2}}
+	b := New(rand.NewSource(1), nodes[0], nodes[1], nodes[2], nodes[3], nodes[4])
+
+	// Requests never complete, the in flight counts stay close
+	counts := map[*node]int{}
+	for i := 0; i < 600; i++ {
+		counts[pick(t, b)]++
+	}
+
+	for _, n := range nodes[:4] {
+		if c := counts[n]; c < 95 || c > 105 {
+			t.Fatalf("%s should be picked about 100 times, got %d", n.name, c)
+		}
+	}
+
+	if c := counts[nodes[4]]; c < 190 || c > 210 {
+		t.Fatalf("e should be picked about 200 times, got %d", c)
+	}
+}
+
+func TestP2CLatency(t *testing.T) {
+	slow, fast := &node{"slow", 1}, &node{"fast", 1}
+	b := New(rand.NewSource(1), slow, fast)
+
+	latency := map[*node]time.Duration{slow: 10 * time.Millisecond, fast: time.Millisecond}
+
+	counts := map[*node]int{}
+	for i := 0; i < 1000; i++ {
+		n := pick(t, b)
+		b.Done(n, latency[n], nil)
+		counts[n]++
+	}
+
+	if counts[fast] < 9*counts[slow] {
+		t.Fatalf("Fast node should take most requests, got %d against %d", counts[fast], counts[slow])
+	}
+}
+
+func TestP2CFailure(t *testing.T) {
+	bad, good := &node{"bad", 1}, &node{"good", 1}
+	b := New(rand.NewSource(1), bad, good)
+
+	counts := map[*node]int{}
+	for i := 0; i < 1000; i++ {
+		n := pick(t, b)
+
+		var err error
+		if n == bad {
+			err = errors.New("failed")
+		}
+
+		b.Done(n, time.Millisecond, err)
+		counts[n]++
+	}
+
+	if counts[good] < 9*counts[bad] {
+		t.Fatalf("Failing node should get few requests, got %d against %d", counts[bad], counts[good])
+	}
+}
+
+func TestP2CSeed(t *testing.T) {
+	a, b, c := &node{"a", 1}, &node{"b", 1}, &node{"c", 1}
+	x, y := New(rand.NewSource(7), a, b, c), New(rand.NewSource(7), a, b, c)
+
+	for i := 0; i < 100; i++ {
+		p, q := pick(t, x), pick(t, y)
+		if p != q {
+			t.Fatal("Same seed should give same picks")
+		}
+
+		x.Done(p, time.Duration(i), nil)
+		y.Done(q, time.Duration(i), nil)
+	}
+}
+
+func TestP2CMembership(t *testing.T) {
+	a, b := &node{"a", 1}, &node{"b", 0}
+	p := New(rand.NewSource(1), a, b)
+
+	// A single candidate is always picked
+	for i := 0; i < 10; i++ {
+		if pick(t, p) != a {
+			t.Fatal("Only a should be picked")
+		}
+	}
+
+	if !p.Remove(a) || p.Remove(a) {
+		t.Fatal("a should be removed once")
+	}
+
+	if _, err := p.Pick(); err != scheduling.ErrNoNode {
+		t.Fatal("Zero weight node should not be picked")
+	}
+
+	p.Add(a)
+	p.Add(a)
+	if pick(t, p) != a {
+		t.Fatal("a should be back")
+	}
+
+	p.Done(&node{"c", 1}, 0, nil)
+
+	if _, err := New(nil).Pick(); err != scheduling.ErrNoNode {
+		t.Fatal("No node should be picked")
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package random picks nodes at random, with chances proportional to their
+// weights.
+package random
+
+import (
+	"github.com/flatpeach/coconut/scheduling"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type Random struct {
+	mu sync.Mutex
+
+	nodes []scheduling.Node
+	rand  *rand.Rand
+}
+
+// Return back a new Random drawing from src, a nil src is seeded with the
+// current time
+func New(src rand.Source, nodes ...scheduling.Node) *Random {
+	if src == nil {
+		src = rand.NewSource(time.Now().UnixNano())
+	}
+
+	r := &Random{
+		nodes: make([]scheduling.Node, len(nodes)),
+		rand:  rand.New(src),
+	}
+
+	copy(r.nodes, nodes)
+
+	return r
+}
+
+// Pick implements the scheduling.Balancer interface
+func (r *Random) Pick() (scheduling.Node, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Weights are read once, they may change while picking
+	weights := make([]int, len(r.nodes))
+	total := 0
+
+	for i, node := range r.nodes {
+		if w := node.Weight(); w > 0 {
+			weights[i] = w
+			total += w
+		}
+	}
+
+	if total == 0 {
+		return nil, scheduling.ErrNoNode
+	}
+
+	x := r.rand.Intn(total)
+	i := 0
+
+	for x >= weights[i] {
+		x -= weights[i]
+		i++
+	}
+
+	return r.nodes[i], nil
+}
+
+// Done implements the scheduling.Balancer interface, picks don't depend on
+// the outcome
+func (r *Random) Done(node scheduling.Node, latency time.Duration, err error) {
+}
+
+// Add one node
+func (r *Random) Add(node scheduling.Node) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nodes = append(r.nodes, node)
+}
+
+// Remove one node, reports whether it was found
+func (r *Random) Remove(node scheduling.Node) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, n := range r.nodes {
+		if n == node {
+			copy(r.nodes[i:], r.nodes[i+1:])
+			r.nodes[len(r.nodes)-1] = nil
+			r.nodes = r.nodes[:len(r.nodes)-1]
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,91 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package random
+
+import (
+	"github.com/flatpeach/coconut/scheduling"
+	"math/rand"
+	"testing"
+)
+
+type node struct {
+	name   string
+	weight int
+}
+
+func (n *node) Weight() int {
+	return n.weight
+}
+
+var _ scheduling.Balancer = (*Random)(nil)
+
+func TestRandomDistribution(t *testing.T) {
+	const n = 100000
+
+	nodes := []*node{{"a", 1}, {"b", 2}, {"c", 0}, {"d", 7}}
+	r := New(rand.NewSource(1), nodes[0], nodes[1], nodes[2], nodes[3])
+
+	counts := map[*node]int{}
+	for i := 0; i < n; i++ {
+		x, err := r.Pick()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Done(x, 0, nil)
+		counts[x.(*node)]++
+	}
+
+	for _, x := range nodes {
+		want := n * x.weight / 10
+		if got := counts[x]; got < want-n/100 || got > want+n/100 {
+			t.Fatalf("%s should be picked about %d times, got %d", x.name, want, got)
+		}
+	}
+
+	if counts[nodes[2]] != 0 {
+		t.Fatal("Zero weight node should not be picked")
+	}
+}
+
+func TestRandomSeed(t *testing.T) {
+	a, b := &node{"a", 1}, &node{"b", 1}
+	x, y := New(rand.NewSource(42), a, b), New(rand.NewSource(42), a, b)
+
+	for i := 0; i < 100; i++ {
+		p, _ := x.Pick()
+		q, _ := y.Pick()
+
+		if p != q {
+			t.Fatal("Same seed should give same picks")
+		}
+	}
+}
+
+func TestRandomMembership(t *testing.T) {
+	a, b := &node{"a", 1}, &node{"b", 1}
+	r := New(rand.NewSource(1), a)
+
+	r.Add(b)
+
+	if !r.Remove(a) || r.Remove(a) {
+		t.Fatal("a should be removed once")
+	}
+
+	for i := 0; i < 10; i++ {
+		if x, _ := r.Pick(); x != b {
+			t.Fatal("Only b should be picked")
+		}
+	}
+
+	b.weight = 0
+	if _, err := r.Pick(); err != scheduling.ErrNoNode {
+		t.Fatal("Zero weight node should not be picked")
+	}
+
+	if _, err := New(nil).Pick(); err != scheduling.ErrNoNode {
+		t.Fatal("No node should be picked")
+	}
+}
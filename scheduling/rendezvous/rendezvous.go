@@ -0,0 +1,183 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package rendezvous provides the weighted rendezvous, or highest random
+// weight, hashing. See "Weighted Distributed Hash Tables" by Schindelhauer
+// and Schomaker for the weighted score.
+//
+// Every node scores each key, and the key belongs to the node of highest
+// score. The score is -weight/ln(u), u being a uniform hash of the key and
+// the node name in (0, 1), so nodes own keys in proportion of their weights.
+// Adding, removing or reweighting a node only moves keys from or to it.
+package rendezvous
+
+import (
+	"github.com/flatpeach/coconut/hash/murmur3"
+	"github.com/flatpeach/coconut/scheduling"
+	"math"
+	"sort"
+	"sync"
+)
+
+type Rendezvous struct {
+	mu sync.Mutex
+
+	peers []peer
+}
+
+type peer struct {
+	node scheduling.NamedNode
+	hash uint64 // hash of the node name
+}
+
+func New(nodes ...scheduling.NamedNode) *Rendezvous {
+	r := &Rendezvous{}
+
+	for _, node := range nodes {
+		r.add(node)
+	}
+
+	return r
+}
+
+func (r *Rendezvous) find(name string) int {
+	for i, p := range r.peers {
+		if p.node.Name() == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// add puts node in place of the node having the same name
+func (r *Rendezvous) add(node scheduling.NamedNode) {
+	p := peer{node, murmur3.Sum64([]byte(node.Name()), 0)}
+
+	if i := r.find(node.Name()); i >= 0 {
+		r.peers[i] = p
+		return
+	}
+
+	r.peers = append(r.peers, p)
+}
+
+// score of the key hashed into h for node p, -1 for zero weight nodes
+func (p *peer) score(h uint64) float64 {
+	w := p.node.Weight()
+	if w <= 0 {
+		return -1
+	}
+
+	// Finalizer of splitmix64 mixes both hashes
+	x := h ^ p.hash
+	x = (x ^ x>>30) * 0xbf58476d1ce4e5b9
+	x = (x ^ x>>27) * 0x94d049bb133111eb
+	x ^= x >> 31
+
+	// 53 bits in the open interval (0, 1)
+	u := (float64(x>>11) + 0.5) / (1 << 53)
+
+	return -float64(w) / math.Log(u)
+}
+
+// Get returns the node key belongs to
+func (r *Rendezvous) Get(key string) (scheduling.NamedNode, error) {
+	h := murmur3.Sum64([]byte(key), 0)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var best scheduling.NamedNode
+	max := 0.0
+
+	for i := range r.peers {
+		if s := r.peers[i].score(h); s > max {
+			best, max = r.peers[i].node, s
+		}
+	}
+
+	if best == nil {
+		return nil, scheduling.ErrNoNode
+	}
+
+	return best, nil
+}
+
+// GetN returns up to n distinct nodes for the replicas of key, by
+// decreasing score. The first one is the node returned by Get.
+func (r *Rendezvous) GetN(key string, n int) ([]scheduling.NamedNode, error) {
+	h := murmur3.Sum64([]byte(key), 0)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	type scored struct {
+		node  scheduling.NamedNode
+		score float64
+	}
+
+	var all []scored
+	for i := range r.peers {
+		if s := r.peers[i].score(h); s > 0 {
+			all = append(all, scored{r.peers[i].node, s})
+		}
+	}
+
+	if len(all) == 0 {
+		return nil, scheduling.ErrNoNode
+	}
+
+	if n <= 0 {
+		return nil, nil
+	}
+
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].score > all[j].score
+	})
+
+	if n > len(all) {
+		n = len(all)
+	}
+
+	nodes := make([]scheduling.NamedNode, 0, n)
+	for _, s := range all[:n] {
+		nodes = append(nodes, s.node)
+	}
+
+	return nodes, nil
+}
+
+// Add one node, a node of the same name is replaced
+func (r *Rendezvous) Add(node scheduling.NamedNode) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.add(node)
+}
+
+// Remove one node, reports whether it was found
+func (r *Rendezvous) Remove(node scheduling.NamedNode) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := r.find(node.Name())
+	if i < 0 {
+		return false
+	}
+
+	copy(r.peers[i:], r.peers[i+1:])
+	r.peers[len(r.peers)-1] = peer{}
+	r.peers = r.peers[:len(r.peers)-1]
+
+	return true
+}
+
+// Len returns how many nodes there are
+func (r *Rendezvous) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.peers)
+}
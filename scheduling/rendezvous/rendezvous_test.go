@@ -0,0 +1,205 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rendezvous
+
+import (
+	"github.com/flatpeach/coconut/scheduling"
+	"strconv"
+	"testing"
+)
+
+type node struct {
+	name   string
+	weight int
+}
+
+func (n *node) Weight() int {
+	return n.weight
+}
+
+func (n *node) Name() string {
+	return n.name
+}
+
+const keys = 100000
+
+func key(i int) string {
+	return "key-" + strconv.Itoa(i)
+}
+
+func assign(t *testing.T, r *Rendezvous) []string {
+	names := make([]string, keys)
+
+	for i := range names {
+		n, err := r.Get(key(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		names[i] = n.Name()
+	}
+
+	return names
+}
+
+func newNodes(weights ...int) []scheduling.NamedNode {
+	var nodes []scheduling.NamedNode
+	for i, w := range weights {
+		nodes = append(nodes, &node{"node-" + strconv.Itoa(i), w})
+	}
+
+	return nodes
+}
+
+func TestRendezvousBalance(t *testing.T) {
+	weights := []int{1, 2, 3, 4}
+	r := New(newNodes(weights...)...)
+
+	counts := map[string]int{}
+	for _, name := range assign(t, r) {
+		counts[name]++
+	}
+
+	for i, w := range weights {
+		want := keys * w / 10
+		if c := counts["node-"+strconv.Itoa(i)]; c < want*95/100 || c > want*105/100 {
+			t.Fatalf("Weight %d should own about %d keys, got %d", w, want, c)
+		}
+	}
+}
+
+func TestRendezvousMembership(t *testing.T) {
+	nodes := newNodes(1, 1, 1, 1, 1)
+	r := New(nodes[:4]...)
+	before := assign(t, r)
+
+	// Adding only moves keys to the new node
+	r.Add(nodes[4])
+	after := assign(t, r)
+
+	moved := 0
+	for i := range before {
+		if before[i] != after[i] {
+			if after[i] != nodes[4].Name() {
+				t.Fatalf("%s moved from %s to %s", key(i), before[i], after[i])
+			}
+
+			moved++
+		}
+	}
+
+	if moved < keys/5*95/100 || moved > keys/5*105/100 {
+		t.Fatalf("About %d keys should move, got %d", keys/5, moved)
+	}
+
+	// Removing only moves the keys of the removed node
+	if !r.Remove(nodes[2]) || r.Remove(nodes[2]) || r.Len() != 4 {
+		t.Fatal("Node should be removed once")
+	}
+
+	for i, name := range assign(t, r) {
+		if after[i] != nodes[2].Name() && name != after[i] {
+			t.Fatalf("%s moved from %s to %s", key(i), after[i], name)
+		}
+	}
+}
+
+func TestRendezvousWeight(t *testing.T) {
+	nodes := newNodes(1, 1, 1)
+	r := New(nodes...)
+	before := assign(t, r)
+
+	// Weights are read at each lookup, keys only move to the heavier node
+	nodes[0].(*node).weight = 2
+	after := assign(t, r)
+
+	gained := 0
+	for i := range before {
+		if before[i] != after[i] {
+			if after[i] != nodes[0].Name() {
+				t.Fatalf("%s moved from %s to %s", key(i), before[i], after[i])
+			}
+
+			gained++
+		}
+	}
+
+	// From 1/3 to 1/2 of the keys
+	if want := keys / 6; gained < want*95/100 || gained > want*105/100 {
+		t.Fatalf("About %d keys should move, got %d", want, gained)
+	}
+
+	// Draining only moves keys away from the drained node
+	nodes[1].(*node).weight = 0
+	for i, name := range assign(t, r) {
+		if name == nodes[1].Name() || (after[i] != nodes[1].Name() && name != after[i]) {
+			t.Fatalf("%s moved from %s to %s", key(i), after[i], name)
+		}
+	}
+}
+
+func TestRendezvousGetN(t *testing.T) {
+	nodes := newNodes(1, 2, 3, 0)
+	r := New(nodes...)
+
+	for i := 0; i < 1000; i++ {
+		first, _ := r.Get(key(i))
+
+		got, err := r.GetN(key(i), 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Zero weight node is left out
+		if len(got) != 3 || got[0] != first || got[0] == got[1] || got[1] == got[2] || got[0] == got[2] {
+			t.Fatalf("Unexpected replicas of %s", key(i))
+		}
+	}
+
+	for _, n := range []int{0, -1, -100} {
+		got, err := r.GetN("x", n)
+		if err != nil || len(got) != 0 {
+			t.Fatalf("GetN of %d should be empty, got %d nodes and %v", n, len(got), err)
+		}
+	}
+}
+
+func TestRendezvousEmpty(t *testing.T) {
+	r := New()
+
+	if _, err := r.Get("x"); err != scheduling.ErrNoNode {
+		t.Fatal("No node should be found")
+	}
+
+	if _, err := r.GetN("x", 1); err != scheduling.ErrNoNode {
+		t.Fatal("No node should be found")
+	}
+
+	r.Add(&node{"a", 0})
+	if _, err := r.Get("x"); err != scheduling.ErrNoNode {
+		t.Fatal("Zero weight node should own no key")
+	}
+
+	// Adding the same name replaces the node
+	a := &node{"a", 1}
+	r.Add(a)
+
+	if n, _ := r.Get("x"); n != a || r.Len() != 1 {
+		t.Fatal("a should be replaced")
+	}
+}
+
+func BenchmarkRendezvousGet(b *testing.B) {
+	var nodes []scheduling.NamedNode
+	for i := 0; i < 100; i++ {
+		nodes = append(nodes, &node{"node-" + strconv.Itoa(i), 1})
+	}
+
+	r := New(nodes...)
+
+	for i := 0; i < b.N; i++ {
+		r.Get("some-key")
+	}
+}
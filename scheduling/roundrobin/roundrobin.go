@@ -5,75 +5,220 @@
 package roundrobin
 
 import (
+	"github.com/flatpeach/coconut/scheduling"
 	"github.com/flatpeach/coconut/util"
+	"sync"
+	"time"
 )
 
+// RoundRobin is the interleaved weighted round-robin.
+//
+// Weights are read when nodes are added, and cached with their gcd and
+// their max so Next doesn't call Weight. Changing the weight of a node
+// must be told with Update, or Reload for many nodes at once.
 type RoundRobin struct {
-	nodes []Node
+	mu sync.Mutex
+
+	nodes   []Node
+	weights []int // cached weights of nodes
+
+	gcd       int // of the positive cached weights
+	maxWeight int // of the cached weights
 
 	index  int
 	weight int
 }
 
-type Node interface {
-	Weight() int
-}
+type Node = scheduling.Node
 
 func New(nodes ...Node) *RoundRobin {
 	rr := &RoundRobin{
-		index:  -1,
-		weight: 0,
-		nodes:  make([]Node, len(nodes)),
+		index:   -1,
+		weight:  0,
+		nodes:   make([]Node, len(nodes)),
+		weights: make([]int, len(nodes)),
 	}
 
 	for idx, node := range nodes {
 		rr.nodes[idx] = node
+		rr.weights[idx] = node.Weight()
 	}
 
+	rr.refresh()
+
 	return rr
 }
 
-func (rr *RoundRobin) gcd() uint {
-	weights := make([]uint, len(rr.nodes))
-
-	for idx, node := range rr.nodes {
-		weights[idx] = uint(node.(Node).Weight())
-	}
-
-	return util.Gcd(weights...)
-}
+// refresh computes the gcd and the max of the cached weights. Zero weights
+// are left out of the gcd since util.Gcd gives 0 for them, it's zero only
+// if there is no positive weight.
+func (rr *RoundRobin) refresh() {
+	var weights []uint
+	rr.maxWeight = 0
 
-func (rr *RoundRobin) maxWeight() int {
-	weight := 0
+	for _, w := range rr.weights {
+		if w > 0 {
+			weights = append(weights, uint(w))
+		}
 
-	for _, node := range rr.nodes {
-		if node.(Node).Weight() > weight {
-			weight = node.(Node).Weight()
+		if w > rr.maxWeight {
+			rr.maxWeight = w
 		}
 	}
 
-	return weight
+	switch len(weights) {
+	case 0:
+		rr.gcd = 0
+	case 1:
+		rr.gcd = int(weights[0])
+	default:
+		rr.gcd = int(util.Gcd(weights...))
+	}
+
+	// Keep the current weight reachable, so the running round goes on
+	// instead of restarting
+	if rr.weight > rr.maxWeight {
+		rr.weight = rr.maxWeight
+	}
 }
 
+// Next returns the next node, or nil if there is no node of positive
+// weight. Nodes of zero or negative weight are drained, they are never
+// returned.
 func (rr *RoundRobin) Next() Node {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	if rr.gcd == 0 {
+		return nil
+	}
+
 	for {
 		rr.index = (rr.index + 1) % len(rr.nodes)
 
 		if rr.index == 0 {
-			rr.weight = rr.weight - int(rr.gcd())
+			rr.weight = rr.weight - rr.gcd
 
 			if rr.weight <= 0 {
-				rr.weight = rr.maxWeight()
-
-				if rr.weight == 0 {
-					return nil
-				}
+				rr.weight = rr.maxWeight
 			}
 		}
 
-		if (rr.nodes[rr.index]).Weight() >= rr.weight {
+		if w := rr.weights[rr.index]; w > 0 && w >= rr.weight {
 			return rr.nodes[rr.index]
 		}
 
 	}
 }
+
+// Pick implements the scheduling.Balancer interface
+func (rr *RoundRobin) Pick() (Node, error) {
+	node := rr.Next()
+	if node == nil {
+		return nil, scheduling.ErrNoNode
+	}
+
+	return node, nil
+}
+
+// Done implements the scheduling.Balancer interface, round-robin doesn't
+// care about the outcome
+func (rr *RoundRobin) Done(node Node, latency time.Duration, err error) {
+}
+
+// Add one node, it joins the running round at the current weight
+func (rr *RoundRobin) Add(node Node) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	rr.nodes = append(rr.nodes, node)
+	rr.weights = append(rr.weights, node.Weight())
+	rr.refresh()
+}
+
+// Remove one node, reports whether it was found. The other nodes keep
+// their place in the running round.
+func (rr *RoundRobin) Remove(node Node) bool {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	for i, n := range rr.nodes {
+		if n != node {
+			continue
+		}
+
+		copy(rr.nodes[i:], rr.nodes[i+1:])
+		rr.nodes[len(rr.nodes)-1] = nil
+		rr.nodes = rr.nodes[:len(rr.nodes)-1]
+
+		copy(rr.weights[i:], rr.weights[i+1:])
+		rr.weights = rr.weights[:len(rr.weights)-1]
+
+		rr.refresh()
+
+		if len(rr.nodes) == 0 {
+			rr.index, rr.weight = -1, 0
+			return true
+		}
+
+		// Step back so the node moved into i is not skipped
+		if i <= rr.index {
+			rr.index--
+
+			// Wrapping to 0 again would lower the weight a second time
+			if rr.index == -1 {
+				rr.weight += rr.gcd
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// Update replaces the node old by node, reports whether old was found. The
+// weight of node is read again and the running round goes on with it. Nodes
+// are compared with ==, so value nodes are told apart by their old value,
+// pointer nodes changed in place can be given twice.
+func (rr *RoundRobin) Update(old, node Node) bool {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	found := false
+
+	for i, n := range rr.nodes {
+		if n == old {
+			rr.nodes[i] = node
+			rr.weights[i] = node.Weight()
+			found = true
+		}
+	}
+
+	if found {
+		rr.refresh()
+	}
+
+	return found
+}
+
+// Reload reads the weights of all nodes again, for when many pointer nodes
+// changed in place. Value nodes must be replaced with Update instead.
+func (rr *RoundRobin) Reload() {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	for i, node := range rr.nodes {
+		rr.weights[i] = node.Weight()
+	}
+
+	rr.refresh()
+}
+
+// Len returns how many nodes are scheduled
+func (rr *RoundRobin) Len() int {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	return len(rr.nodes)
+}
@@ -5,11 +5,18 @@
 package roundrobin
 
 import (
+	"github.com/flatpeach/coconut/scheduling"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 )
 
+var (
+	_ scheduling.Balancer = (*RoundRobin)(nil)
+	_ scheduling.Balancer = (*Smooth)(nil)
+)
+
 type MyServer struct {
 	id     int
 	weight int
@@ -61,3 +68,403 @@ func TestWeightedRR(t *testing.T) {
 	}
 
 }
+
+type node struct {
+	name   string
+	weight int
+}
+
+func (n *node) Weight() int {
+	return n.weight
+}
+
+// sequence returns the names of the next n nodes
+func sequence(rr interface{ Next() Node }, n int) string {
+	s := ""
+	for i := 0; i < n; i++ {
+		s += rr.Next().(*node).name
+	}
+
+	return s
+}
+
+func TestRoundRobin(t *testing.T) {
+	a, b, c := &node{"a", 4}, &node{"b", 3}, &node{"c", 2}
+	rr := New(a, b, c)
+
+	if s := sequence(rr, 18); s != "aababcabcaababcabc" {
+		t.Fatalf("Unexpected sequence %s", s)
+	}
+}
+
+func TestRoundRobinAdd(t *testing.T) {
+	a, b, c := &node{"a", 1}, &node{"b", 1}, &node{"c", 1}
+	rr := New(a, b)
+
+	if s := sequence(rr, 1); s != "a" {
+		t.Fatalf("Unexpected sequence %s", s)
+	}
+
+	// c joins the running round
+	rr.Add(c)
+
+	if s := sequence(rr, 5); s != "bcabc" {
+		t.Fatalf("Unexpected sequence %s", s)
+	}
+
+	if rr.Len() != 3 {
+		t.Fatal("Should have 3 nodes")
+	}
+}
+
+func TestRoundRobinRemove(t *testing.T) {
+	cases := []struct {
+		before string
+		remove int
+		after  string
+	}{
+		{"a", 0, "bcbc"},   // the last picked one at the head
+		{"ab", 0, "cbc"},   // one before the last picked one
+		{"ab", 1, "cac"},   // the last picked one
+		{"ab", 2, "aba"},   // one not picked yet in this round
+		{"abc", 2, "aba"},  // the last picked one at the tail
+		{"abca", 1, "cac"}, // the next one
+	}
+
+	for _, c := range cases {
+		nodes := []*node{{"a", 1}, {"b", 1}, {"c", 1}}
+		rr := New(nodes[0], nodes[1], nodes[2])
+
+		if s := sequence(rr, len(c.before)); s != c.before {
+			t.Fatalf("Unexpected sequence %s", s)
+		}
+
+		if !rr.Remove(nodes[c.remove]) {
+			t.Fatal("Node should be removed")
+		}
+
+		if s := sequence(rr, len(c.after)); s != c.after {
+			t.Fatalf("After %s and removing %s, should be %s, got %s", c.before, nodes[c.remove].name, c.after, s)
+		}
+	}
+
+	rr := New(&node{"a", 1})
+	if rr.Remove(&node{"a", 1}) {
+		t.Fatal("Only the same node should be removed")
+	}
+}
+
+func TestRoundRobinRemoveWeighted(t *testing.T) {
+	a, b, c := &node{"a", 4}, &node{"b", 3}, &node{"c", 2}
+	rr := New(a, b, c)
+
+	// In the middle of the round at weight 2
+	if s := sequence(rr, 4); s != "aaba" {
+		t.Fatalf("Unexpected sequence %s", s)
+	}
+
+	rr.Remove(a)
+
+	if s := sequence(rr, 6); s != "bcbcbb" {
+		t.Fatalf("Unexpected sequence %s", s)
+	}
+}
+
+func TestRoundRobinUpdate(t *testing.T) {
+	a, b := &node{"a", 3}, &node{"b", 1}
+	rr := New(a, b)
+
+	if s := sequence(rr, 1); s != "a" {
+		t.Fatalf("Unexpected sequence %s", s)
+	}
+
+	a.weight = 1
+	if !rr.Update(a, a) {
+		t.Fatal("a should be found")
+	}
+
+	if s := sequence(rr, 4); s != "baba" {
+		t.Fatalf("Unexpected sequence %s", s)
+	}
+
+	c := &node{"c", 1}
+	if rr.Update(c, c) {
+		t.Fatal("c was never added")
+	}
+}
+
+func TestRoundRobinUpdateValue(t *testing.T) {
+	rr := New(MyServer{1, 10}, MyServer{2, 10})
+
+	if rr.Update(MyServer{1, 20}, MyServer{1, 30}) {
+		t.Fatal("Value nodes should be matched by their old value")
+	}
+
+	if !rr.Update(MyServer{1, 10}, MyServer{1, 30}) {
+		t.Fatal("MyServer{1, 10} should be found")
+	}
+
+	// Reload reads the stored value, not the replaced one
+	rr.Reload()
+
+	counts := map[Node]int{}
+	for i := 0; i < 40; i++ {
+		counts[rr.Next()]++
+	}
+
+	if counts[MyServer{1, 30}] != 30 || counts[MyServer{2, 10}] != 10 {
+		t.Fatalf("Unexpected counts %v", counts)
+	}
+}
+
+func TestRoundRobinConcurrent(t *testing.T) {
+	nodes := []*node{{"a", 5}, {"b", 3}, {"c", 2}, {"d", 1}}
+	rr := New(nodes[0], nodes[1], nodes[2])
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	counts := map[string]int{}
+
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			local := map[string]int{}
+			for i := 0; i < 10000; i++ {
+				local[rr.Next().(*node).name]++
+			}
+
+			mu.Lock()
+			for k, v := range local {
+				counts[k] += v
+			}
+			mu.Unlock()
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < 1000; i++ {
+			rr.Add(nodes[3])
+			rr.Remove(nodes[3])
+		}
+	}()
+
+	wg.Wait()
+
+	total := 0
+	for _, v := range counts {
+		total += v
+	}
+
+	if total != 80000 || rr.Len() != 3 {
+		t.Fatalf("Should pick 80000 times out of 3 nodes, got %d out of %d", total, rr.Len())
+	}
+
+	// d comes and goes, the others keep their share
+	if counts["a"] < counts["b"] || counts["b"] < counts["c"] {
+		t.Fatalf("Unfair counts %v", counts)
+	}
+}
+
+func TestRoundRobinPick(t *testing.T) {
+	a := &node{"a", 1}
+	rr := New(a)
+
+	if n, err := rr.Pick(); n != a || err != nil {
+		t.Fatal("a should be picked")
+	}
+
+	rr.Done(a, 0, nil)
+}
+
+func TestRoundRobinDrained(t *testing.T) {
+	cases := []struct {
+		name     string
+		weights  []int
+		sequence string // "-" stands for no node
+	}{
+		{"empty", nil, "---"},
+		{"single zero", []int{0}, "---"},
+		{"all zero", []int{0, 0, 0}, "---"},
+		{"negative", []int{-1, 0}, "---"},
+		{"single", []int{3}, "aaa"},
+		{"single among zeros", []int{0, 0, 2}, "ccc"},
+		{"zero in the middle", []int{2, 0, 1}, "aacaac"},
+		{"zero and negative", []int{0, 4, -2, 2}, "bbdbbdbb"},
+		{"coprime", []int{0, 3, 2}, "bbcbcbbcbc"},
+	}
+
+	for _, c := range cases {
+		var nodes []Node
+		for i, w := range c.weights {
+			nodes = append(nodes, &node{string(rune('a' + i)), w})
+		}
+
+		rr := New(nodes...)
+
+		s := ""
+		for range c.sequence {
+			n, err := rr.Pick()
+			if err != nil {
+				if err != scheduling.ErrNoNode || rr.Next() != nil {
+					t.Fatalf("%s: unexpected error %v", c.name, err)
+				}
+
+				s += "-"
+				continue
+			}
+
+			s += n.(*node).name
+		}
+
+		if s != c.sequence {
+			t.Fatalf("%s: should be %s, got %s", c.name, c.sequence, s)
+		}
+	}
+}
+
+func TestRoundRobinDrainedLater(t *testing.T) {
+	cases := []struct {
+		name  string
+		drain []int // nodes set to zero weight after 2 picks
+		after string
+	}{
+		{"one", []int{0}, "cbcb"},
+		{"all", []int{0, 1, 2}, "----"},
+		{"last picked", []int{1}, "caca"},
+	}
+
+	for _, c := range cases {
+		nodes := []*node{{"a", 1}, {"b", 1}, {"c", 1}}
+		rr := New(nodes[0], nodes[1], nodes[2])
+
+		if s := sequence(rr, 2); s != "ab" {
+			t.Fatalf("%s: unexpected sequence %s", c.name, s)
+		}
+
+		for _, i := range c.drain {
+			nodes[i].weight = 0
+			rr.Update(nodes[i], nodes[i])
+		}
+
+		s := ""
+		for range c.after {
+			if n := rr.Next(); n != nil {
+				s += n.(*node).name
+			} else {
+				s += "-"
+			}
+		}
+
+		if s != c.after {
+			t.Fatalf("%s: should be %s, got %s", c.name, c.after, s)
+		}
+
+		for _, n := range nodes {
+			n.weight = 1
+			rr.Update(n, n)
+		}
+
+		// Restored nodes get their share again
+		counts := map[rune]int{}
+		for _, r := range sequence(rr, 6) {
+			counts[r]++
+		}
+
+		if counts['a'] != 2 || counts['b'] != 2 || counts['c'] != 2 {
+			t.Fatalf("%s: unfair counts %v", c.name, counts)
+		}
+	}
+}
+
+// countingNode counts the calls to Weight
+type countingNode struct {
+	weight int
+	calls  int
+}
+
+func (n *countingNode) Weight() int {
+	n.calls++
+	return n.weight
+}
+
+func TestRoundRobinCachedWeights(t *testing.T) {
+	a, b := &countingNode{weight: 3}, &countingNode{weight: 1}
+	rr := New(a, b)
+
+	calls := a.calls + b.calls
+	for i := 0; i < 100; i++ {
+		rr.Next()
+	}
+
+	if a.calls+b.calls != calls {
+		t.Fatal("Next should not read the weights")
+	}
+
+	// Not seen until told
+	a.weight = 1
+
+	counts := map[Node]int{}
+	for i := 0; i < 40; i++ {
+		counts[rr.Next()]++
+	}
+
+	if counts[a] != 30 || counts[b] != 10 {
+		t.Fatalf("Cached weights should be used, got %d and %d", counts[a], counts[b])
+	}
+
+	rr.Reload()
+
+	counts = map[Node]int{}
+	for i := 0; i < 40; i++ {
+		counts[rr.Next()]++
+	}
+
+	if counts[a] != 20 || counts[b] != 20 {
+		t.Fatalf("Reloaded weights should be used, got %d and %d", counts[a], counts[b])
+	}
+}
+
+func benchmarkRoundRobinNext(b *testing.B, n int) {
+	var nodes []Node
+	for i := 0; i < n; i++ {
+		nodes = append(nodes, &node{"", 1 + i%10})
+	}
+
+	rr := New(nodes...)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rr.Next()
+	}
+}
+
+func BenchmarkRoundRobinNext10(b *testing.B) {
+	benchmarkRoundRobinNext(b, 10)
+}
+
+func BenchmarkRoundRobinNext1000(b *testing.B) {
+	benchmarkRoundRobinNext(b, 1000)
+}
+
+func BenchmarkRoundRobinNext10000(b *testing.B) {
+	benchmarkRoundRobinNext(b, 10000)
+}
+
+func BenchmarkRoundRobinUpdate(b *testing.B) {
+	var nodes []Node
+	for i := 0; i < 1000; i++ {
+		nodes = append(nodes, &node{"", 1 + i%10})
+	}
+
+	rr := New(nodes...)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rr.Update(nodes[i%len(nodes)], nodes[i%len(nodes)])
+	}
+}
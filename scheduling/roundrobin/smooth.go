@@ -0,0 +1,182 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package roundrobin
+
+import (
+	"github.com/flatpeach/coconut/scheduling"
+	"sync"
+	"time"
+)
+
+// Smooth is the smooth weighted round-robin of nginx. Picks of heavy nodes
+// are spread among the others instead of coming in bursts, weights 5, 1, 1
+// give a a b a c a a.
+//
+// Every node has a current weight, raised by its effective weight at each
+// pick. The node with the highest current weight is picked and lowered by
+// the total of the effective weights.
+type Smooth struct {
+	mu sync.Mutex
+
+	peers []*peer
+}
+
+type peer struct {
+	node      Node
+	current   int
+	effective int // weight in use, lowered by Fail and recovering over picks
+}
+
+func NewSmooth(nodes ...Node) *Smooth {
+	s := &Smooth{
+		peers: make([]*peer, 0, len(nodes)),
+	}
+
+	for _, node := range nodes {
+		s.peers = append(s.peers, newPeer(node))
+	}
+
+	return s
+}
+
+func newPeer(node Node) *peer {
+	return &peer{
+		node:      node,
+		effective: node.Weight(),
+	}
+}
+
+// Next returns the next node, or nil if there is no node of positive weight
+func (s *Smooth) Next() Node {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var best *peer
+	total := 0
+
+	for _, p := range s.peers {
+		weight := p.node.Weight()
+		if weight <= 0 {
+			continue
+		}
+
+		if p.effective > weight {
+			p.effective = weight
+		}
+
+		p.current += p.effective
+		total += p.effective
+
+		if p.effective < weight {
+			p.effective++
+		}
+
+		if best == nil || p.current > best.current {
+			best = p
+		}
+	}
+
+	if best == nil {
+		return nil
+	}
+
+	best.current -= total
+
+	return best.node
+}
+
+// Pick implements the scheduling.Balancer interface
+func (s *Smooth) Pick() (Node, error) {
+	node := s.Next()
+	if node == nil {
+		return nil, scheduling.ErrNoNode
+	}
+
+	return node, nil
+}
+
+// Done implements the scheduling.Balancer interface, a failed node is
+// passed to Fail
+func (s *Smooth) Done(node Node, latency time.Duration, err error) {
+	if err != nil {
+		s.Fail(node)
+	}
+}
+
+func (s *Smooth) find(node Node) int {
+	for i, p := range s.peers {
+		if p.node == node {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Add one node, it starts with a zero current weight
+func (s *Smooth) Add(node Node) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.peers = append(s.peers, newPeer(node))
+}
+
+// Remove one node, reports whether it was found
+func (s *Smooth) Remove(node Node) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.find(node)
+	if i < 0 {
+		return false
+	}
+
+	copy(s.peers[i:], s.peers[i+1:])
+	s.peers[len(s.peers)-1] = nil
+	s.peers = s.peers[:len(s.peers)-1]
+
+	return true
+}
+
+// Update replaces the node old by node, reports whether old was found. Its
+// weight is read again, the current weight is kept.
+func (s *Smooth) Update(old, node Node) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.find(old)
+	if i < 0 {
+		return false
+	}
+
+	s.peers[i].node = node
+	s.peers[i].effective = node.Weight()
+
+	return true
+}
+
+// Fail tells one node failed, reports whether it was found. Its effective
+// weight is halved, then recovers by one at each pick.
+func (s *Smooth) Fail(node Node) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.find(node)
+	if i < 0 {
+		return false
+	}
+
+	s.peers[i].effective /= 2
+
+	return true
+}
+
+// Len returns how many nodes are scheduled
+func (s *Smooth) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.peers)
+}
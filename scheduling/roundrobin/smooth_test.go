@@ -0,0 +1,185 @@
+// Copyright 2014 The coconut Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package roundrobin
+
+import (
+	"errors"
+	"github.com/flatpeach/coconut/scheduling"
+	"testing"
+)
+
+func TestSmooth(t *testing.T) {
+	cases := []struct {
+		weights  []int
+		sequence string
+	}{
+		{[]int{5, 1, 1}, "aabacaa"},
+		{[]int{4, 3, 2}, "abcabacba"},
+		{[]int{1, 1, 1}, "abc"},
+		{[]int{3, 0, 1}, "aaca"},
+	}
+
+	for _, c := range cases {
+		var nodes []Node
+		for i, w := range c.weights {
+			nodes = append(nodes, &node{string(rune('a' + i)), w})
+		}
+
+		s := NewSmooth(nodes...)
+
+		// The same sequence over and over
+		for round := 0; round < 3; round++ {
+			if seq := sequence(s, len(c.sequence)); seq != c.sequence {
+				t.Fatalf("Weights %v should give %s, got %s", c.weights, c.sequence, seq)
+			}
+		}
+	}
+}
+
+func TestSmoothDistribution(t *testing.T) {
+	weights := []int{10, 7, 3, 1}
+
+	var nodes []Node
+	total := 0
+	for i, w := range weights {
+		nodes = append(nodes, &node{string(rune('a' + i)), w})
+		total += w
+	}
+
+	s := NewSmooth(nodes...)
+
+	counts := map[string]int{}
+	last, burst, longest := "", 0, 0
+
+	for i := 0; i < total*1000; i++ {
+		name := s.Next().(*node).name
+		counts[name]++
+
+		if name == last {
+			burst++
+		} else {
+			last, burst = name, 1
+		}
+
+		if burst > longest {
+			longest = burst
+		}
+	}
+
+	for i, w := range weights {
+		if n := counts[string(rune('a'+i))]; n != w*1000 {
+			t.Fatalf("Weight %d should be picked %d times, got %d", w, w*1000, n)
+		}
+	}
+
+	// The heaviest node is only half of the picks, it never comes 3 times in a row
+	if longest > 2 {
+		t.Fatalf("Picks should be spread, got a burst of %d", longest)
+	}
+}
+
+func TestSmoothMembership(t *testing.T) {
+	a, b, c := &node{"a", 2}, &node{"b", 1}, &node{"c", 1}
+	s := NewSmooth(a, b)
+
+	if seq := sequence(s, 3); seq != "aba" {
+		t.Fatalf("Unexpected sequence %s", seq)
+	}
+
+	s.Add(c)
+	if seq := sequence(s, 8); seq != "acabacab" && seq != "abacabac" {
+		counts := map[rune]int{}
+		for _, r := range seq {
+			counts[r]++
+		}
+
+		if counts['a'] != 4 || counts['b'] != 2 || counts['c'] != 2 {
+			t.Fatalf("Unexpected sequence %s", seq)
+		}
+	}
+
+	if !s.Remove(a) || s.Remove(a) {
+		t.Fatal("a should be removed once")
+	}
+
+	if seq := sequence(s, 4); seq != "bcbc" && seq != "cbcb" {
+		t.Fatalf("Unexpected sequence %s", seq)
+	}
+
+	if s.Len() != 2 {
+		t.Fatal("Should have 2 nodes")
+	}
+
+	b.weight = 3
+	if !s.Update(b, b) || s.Update(a, a) {
+		t.Fatal("Only b should be updated")
+	}
+
+	counts := map[string]int{}
+	for i := 0; i < 400; i++ {
+		counts[s.Next().(*node).name]++
+	}
+
+	if counts["b"] != 300 || counts["c"] != 100 {
+		t.Fatalf("Unexpected counts %v", counts)
+	}
+}
+
+func TestSmoothFail(t *testing.T) {
+	a, b := &node{"a", 100}, &node{"b", 100}
+	s := NewSmooth(a, b)
+
+	if !s.Fail(a) || s.Fail(&node{"c", 1}) {
+		t.Fatal("Only a should fail")
+	}
+
+	// a gets less picks while recovering, then its full share again
+	counts := map[string]int{}
+	for i := 0; i < 100; i++ {
+		counts[s.Next().(*node).name]++
+	}
+
+	if counts["a"] >= counts["b"] {
+		t.Fatalf("a should be picked less, got %v", counts)
+	}
+
+	counts = map[string]int{}
+	for i := 0; i < 2000; i++ {
+		counts[s.Next().(*node).name]++
+	}
+
+	if d := counts["a"] - counts["b"]; d < -2 || d > 2 {
+		t.Fatalf("a should have recovered, got %v", counts)
+	}
+}
+
+func TestSmoothEmpty(t *testing.T) {
+	if NewSmooth().Next() != nil {
+		t.Fatal("No node should be picked")
+	}
+
+	if NewSmooth(&node{"a", 0}).Next() != nil {
+		t.Fatal("Zero weight node should not be picked")
+	}
+}
+
+func TestSmoothPick(t *testing.T) {
+	a := &node{"a", 4}
+	s := NewSmooth(a)
+
+	n, err := s.Pick()
+	if n != a || err != nil {
+		t.Fatal("a should be picked")
+	}
+
+	s.Done(a, 0, errors.New("failed"))
+	if s.peers[0].effective != 2 {
+		t.Fatal("Failure should halve the effective weight")
+	}
+
+	if _, err := NewSmooth().Pick(); err != scheduling.ErrNoNode {
+		t.Fatal("No node should be picked")
+	}
+}